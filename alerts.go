@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/keep-network/prometheus-sd/internal/alerting"
+)
+
+const (
+	alertSourcesUnreachable = "KeepSDSourcesUnreachable"
+	alertPeerCountDrop      = "KeepSDPeerCountDrop"
+	alertNetworkIDConflict  = "KeepSDNetworkIDConflict"
+	alertRoundOverrun       = "KeepSDRoundOverrun"
+)
+
+// roundStats describes a completed discovery round for the alerts evaluation.
+type roundStats struct {
+	sources          int
+	reachableSources int
+
+	peers         int
+	previousPeers int
+
+	networkIDConflicts []string
+
+	duration time.Duration
+}
+
+// evaluateAlerts returns alerts firing for the discovery round.
+func evaluateAlerts(stats roundStats) []alerting.Alert {
+	alerts := make([]alerting.Alert, 0)
+
+	if stats.sources > 0 && stats.reachableSources == 0 {
+		alerts = append(alerts, alerting.Alert{
+			Labels: map[string]string{
+				"alertname": alertSourcesUnreachable,
+				"severity":  "critical",
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("all %d diagnostics sources are unreachable", stats.sources),
+			},
+		})
+	}
+
+	if stats.previousPeers > 0 {
+		drop := float64(stats.previousPeers-stats.peers) / float64(stats.previousPeers)
+		if drop > config.alertmanagerPeerDropThreshold {
+			alerts = append(alerts, alerting.Alert{
+				Labels: map[string]string{
+					"alertname": alertPeerCountDrop,
+					"severity":  "warning",
+				},
+				Annotations: map[string]string{
+					"summary": fmt.Sprintf(
+						"peer count dropped from %d to %d (%.0f%%)",
+						stats.previousPeers,
+						stats.peers,
+						100*drop,
+					),
+				},
+			})
+		}
+	}
+
+	for _, chainAddress := range stats.networkIDConflicts {
+		alerts = append(alerts, alerting.Alert{
+			Labels: map[string]string{
+				"alertname":     alertNetworkIDConflict,
+				"severity":      "warning",
+				"chain_address": chainAddress,
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf("sources report different network IDs for peer %s", chainAddress),
+			},
+		})
+	}
+
+	if stats.duration > config.refreshInterval {
+		alerts = append(alerts, alerting.Alert{
+			Labels: map[string]string{
+				"alertname": alertRoundOverrun,
+				"severity":  "warning",
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf(
+					"discovery round took %s which exceeds the refresh interval of %s",
+					stats.duration.Round(time.Second),
+					config.refreshInterval,
+				),
+			},
+		})
+	}
+
+	return alerts
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+
+	"github.com/keep-network/prometheus-sd/internal/alerting"
+)
+
+// alertNames returns names of the alerts, suffixed with the chain address if
+// the alert is about a peer, in a stable order.
+func alertNames(alerts []alerting.Alert) []string {
+	names := make([]string, 0, len(alerts))
+	for _, alert := range alerts {
+		name := alert.Labels["alertname"]
+		if chainAddress, ok := alert.Labels["chain_address"]; ok {
+			name += "/" + chainAddress
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	healthy := roundStats{
+		sources:          2,
+		reachableSources: 2,
+		peers:            10,
+		previousPeers:    10,
+		duration:         10 * time.Second,
+	}
+
+	var tests = map[string]struct {
+		stats           func(stats *roundStats)
+		expectedAlerts  []string
+		expectedSummary string
+	}{
+		"healthy round": {
+			stats:          func(stats *roundStats) {},
+			expectedAlerts: []string{},
+		},
+		"all sources unreachable": {
+			stats: func(stats *roundStats) {
+				stats.reachableSources = 0
+			},
+			expectedAlerts:  []string{alertSourcesUnreachable},
+			expectedSummary: "all 2 diagnostics sources are unreachable",
+		},
+		"some sources unreachable": {
+			stats: func(stats *roundStats) {
+				stats.reachableSources = 1
+			},
+			expectedAlerts: []string{},
+		},
+		"no sources configured": {
+			stats: func(stats *roundStats) {
+				stats.sources = 0
+				stats.reachableSources = 0
+			},
+			expectedAlerts: []string{},
+		},
+		"drop at threshold": {
+			stats: func(stats *roundStats) {
+				stats.peers = 8
+			},
+			expectedAlerts: []string{},
+		},
+		"drop just over threshold": {
+			stats: func(stats *roundStats) {
+				stats.previousPeers = 1000
+				stats.peers = 799
+			},
+			expectedAlerts:  []string{alertPeerCountDrop},
+			expectedSummary: "peer count dropped from 1000 to 799 (20%)",
+		},
+		"all peers lost": {
+			stats: func(stats *roundStats) {
+				stats.peers = 0
+			},
+			expectedAlerts:  []string{alertPeerCountDrop},
+			expectedSummary: "peer count dropped from 10 to 0 (100%)",
+		},
+		"no previous peers": {
+			stats: func(stats *roundStats) {
+				stats.previousPeers = 0
+				stats.peers = 0
+			},
+			expectedAlerts: []string{},
+		},
+		"peer count increase": {
+			stats: func(stats *roundStats) {
+				stats.peers = 20
+			},
+			expectedAlerts: []string{},
+		},
+		"network id conflicts": {
+			stats: func(stats *roundStats) {
+				stats.networkIDConflicts = []string{"0xA", "0xB"}
+			},
+			expectedAlerts: []string{
+				alertNetworkIDConflict + "/0xA",
+				alertNetworkIDConflict + "/0xB",
+			},
+		},
+		"round at refresh interval": {
+			stats: func(stats *roundStats) {
+				stats.duration = time.Minute
+			},
+			expectedAlerts: []string{},
+		},
+		"round overrun": {
+			stats: func(stats *roundStats) {
+				stats.duration = time.Minute + time.Second
+			},
+			expectedAlerts:  []string{alertRoundOverrun},
+			expectedSummary: "discovery round took 1m1s which exceeds the refresh interval of 1m0s",
+		},
+		"all anomalies": {
+			stats: func(stats *roundStats) {
+				stats.reachableSources = 0
+				stats.peers = 0
+				stats.networkIDConflicts = []string{"0xA"}
+				stats.duration = 2 * time.Minute
+			},
+			expectedAlerts: []string{
+				alertNetworkIDConflict + "/0xA",
+				alertPeerCountDrop,
+				alertRoundOverrun,
+				alertSourcesUnreachable,
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			parseFlags(t, "run", "--refresh.interval=1m", "--alertmanager.peerDropThreshold=0.2")
+
+			stats := healthy
+			test.stats(&stats)
+
+			alerts := evaluateAlerts(stats)
+			if names := alertNames(alerts); !reflect.DeepEqual(names, test.expectedAlerts) {
+				t.Errorf("unexpected alerts\nexpected: %v\nactual:   %v", test.expectedAlerts, names)
+			}
+
+			if test.expectedSummary != "" && len(alerts) == 1 {
+				if summary := alerts[0].Annotations["summary"]; summary != test.expectedSummary {
+					t.Errorf("unexpected summary\nexpected: %s\nactual:   %s", test.expectedSummary, summary)
+				}
+			}
+		})
+	}
+}
+
+// fakeAlertmanager records alerts posted to its API.
+type fakeAlertmanager struct {
+	mutex sync.Mutex
+	posts [][]alerting.Alert
+}
+
+func (am *fakeAlertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/api/v2/alerts" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var alerts []alerting.Alert
+	if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+	am.posts = append(am.posts, alerts)
+}
+
+// takePosts returns the alerts posted since the last call.
+func (am *fakeAlertmanager) takePosts() [][]alerting.Alert {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	posts := am.posts
+	am.posts = nil
+	return posts
+}
+
+func TestPublishAlerts(t *testing.T) {
+	alertmanager := &fakeAlertmanager{}
+	server := httptest.NewServer(alertmanager)
+	defer server.Close()
+
+	d, _ := newTestDiscovery(
+		t,
+		"--source.address=192.0.2.1:9701",
+		"--refresh.interval=1m",
+		"--alertmanager.url="+server.URL,
+		"--alertmanager.peerDropThreshold=0.2",
+	)
+	d.notifier = alerting.NewNotifier(
+		alerting.NewClient(config.alertmanagerURLs, time.Second),
+		3*config.refreshInterval,
+	)
+
+	ctx := context.Background()
+
+	// The first poll reaches the source and finds a network ID conflict.
+	d.sourceDiagnostics = []clientinfo.Diagnostics{*nodeDiagnostics("0xS")}
+	d.networkIDConflicts = []string{"0xC"}
+	d.peers = map[string]*peerData{
+		"0xA": resolvedPeer("0xA", "192.0.2.10:9601"),
+		"0xB": resolvedPeer("0xB", "192.0.2.11:9601"),
+	}
+	if !d.publish(ctx, pollPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+
+	posts := alertmanager.takePosts()
+	if len(posts) != 1 {
+		t.Fatalf("unexpected number of posts: %d", len(posts))
+	}
+	assertAlerts(t, posts[0], []string{alertNetworkIDConflict + "/0xC"}, nil)
+
+	// Passes other than polls don't evaluate alerts.
+	d.peers = map[string]*peerData{}
+	if !d.publish(ctx, verifyPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	if posts := alertmanager.takePosts(); len(posts) != 0 {
+		t.Fatalf("alerts posted after verify pass: %v", posts)
+	}
+
+	// The next poll loses the source and all peers; the conflict is resolved.
+	d.sourceDiagnostics = nil
+	d.networkIDConflicts = nil
+	if !d.publish(ctx, pollPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+
+	posts = alertmanager.takePosts()
+	if len(posts) != 1 {
+		t.Fatalf("unexpected number of posts: %d", len(posts))
+	}
+	assertAlerts(
+		t,
+		posts[0],
+		[]string{alertPeerCountDrop, alertSourcesUnreachable},
+		[]string{alertNetworkIDConflict + "/0xC"},
+	)
+}
+
+// assertAlerts checks names of the firing and resolved alerts posted to
+// Alertmanager.
+func assertAlerts(t *testing.T, alerts []alerting.Alert, expectedFiring, expectedResolved []string) {
+	t.Helper()
+
+	firing := make([]alerting.Alert, 0)
+	resolved := make([]alerting.Alert, 0)
+	for _, alert := range alerts {
+		if alert.EndsAt.After(time.Now()) {
+			firing = append(firing, alert)
+		} else {
+			resolved = append(resolved, alert)
+		}
+	}
+
+	if expectedResolved == nil {
+		expectedResolved = []string{}
+	}
+	if names := alertNames(firing); !reflect.DeepEqual(names, expectedFiring) {
+		t.Errorf("unexpected firing alerts\nexpected: %v\nactual:   %v", expectedFiring, names)
+	}
+	if names := alertNames(resolved); !reflect.DeepEqual(names, expectedResolved) {
+		t.Errorf("unexpected resolved alerts\nexpected: %v\nactual:   %v", expectedResolved, names)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/keep-network/prometheus-sd/internal/dashboard"
+	"github.com/keep-network/prometheus-sd/internal/geoip"
+	"github.com/keep-network/prometheus-sd/internal/health"
+	"github.com/keep-network/prometheus-sd/internal/registry"
+)
+
+var (
+	dashboardCmd         = app.Command("dashboard", "Generate a Grafana dashboard for the discovered targets.")
+	dashboardGenerateCmd = dashboardCmd.Command("generate", "Generate a dashboard for the labels and metrics exported by the discovery.")
+
+	dashboardConfig dashboard.Config
+	dashboardOutput string
+)
+
+// dashboardGroupBy are the target labels the dashboard counts peers by.
+var dashboardGroupBy = []model.LabelName{
+	model.LabelName(labelNetworkID),
+	model.LabelName(labelVersion),
+	model.MetaLabelPrefix + "keep_operator_name",
+	model.MetaLabelPrefix + "keep_geo_country",
+}
+
+func init() {
+	dashboardGenerateCmd.Flag(
+		"dashboard.title",
+		"Title of the dashboard.",
+	).Default("Keep Network Nodes").StringVar(&dashboardConfig.Title)
+
+	dashboardGenerateCmd.Flag(
+		"dashboard.uid",
+		"Unique identifier of the dashboard in Grafana.",
+	).Default("keep-network-nodes").StringVar(&dashboardConfig.UID)
+
+	dashboardGenerateCmd.Flag(
+		"dashboard.nodesJob",
+		"Name of the Prometheus job scraping the discovered nodes.",
+	).Default("keep-network-nodes").StringVar(&dashboardConfig.NodesJob)
+
+	dashboardGenerateCmd.Flag(
+		"dashboard.sdJob",
+		"Name of the Prometheus job scraping the discovery's own metrics.",
+	).Default("keep-sd").StringVar(&dashboardConfig.SDJob)
+
+	dashboardGenerateCmd.Flag(
+		"dashboard.endpointKind",
+		"Kind of the nodes' targets shown in the panels if peers have a target per --target.path, so each peer counts once. Targets without a kind are always shown. All targets are shown, filtered by a variable, if empty.",
+	).Default("metrics").StringVar(&dashboardConfig.EndpointKind)
+
+	dashboardGenerateCmd.Flag(
+		"dashboard.output",
+		"File to write the dashboard to. The dashboard is printed if empty.",
+	).Default("").StringVar(&dashboardOutput)
+}
+
+// exportedLabels returns names of all meta labels the discovery may set on
+// the targets.
+func exportedLabels() []model.LabelName {
+	labels := []model.LabelName{
+		model.LabelName(labelChainAddress),
+		model.LabelName(labelNetworkID),
+		model.LabelName(labelVersion),
+		model.LabelName(labelVerified),
+		model.LabelName(labelEndpointKind),
+	}
+	labels = append(labels, registry.LabelNames()...)
+	return append(labels, geoip.LabelNames()...)
+}
+
+// targetLabel returns the name the meta label is expected to be relabeled to,
+// as with the labelmap action and __meta_(?:keep_)?(.+) regex.
+func targetLabel(label model.LabelName) string {
+	name := strings.TrimPrefix(string(label), model.MetaLabelPrefix)
+	return strings.TrimPrefix(name, "keep_")
+}
+
+// runDashboardGenerate generates the dashboard and writes it to the output
+// file or the writer.
+func runDashboardGenerate(w io.Writer) error {
+	cfg := dashboardConfig
+	cfg.EndpointKindLabel = targetLabel(model.LabelName(labelEndpointKind))
+
+	for _, label := range exportedLabels() {
+		// Tags are lists matched with regexes, not values to choose from.
+		if label == registry.LabelTags {
+			continue
+		}
+		// Panels show a single endpoint kind if it's set.
+		if label == model.LabelName(labelEndpointKind) && cfg.EndpointKind != "" {
+			continue
+		}
+		cfg.Labels = append(cfg.Labels, targetLabel(label))
+	}
+	for _, label := range dashboardGroupBy {
+		cfg.GroupBy = append(cfg.GroupBy, targetLabel(label))
+	}
+	for _, gauge := range health.Gauges() {
+		cfg.Metrics = append(cfg.Metrics, dashboard.Metric{
+			Name:   gauge.FullName(),
+			Help:   gauge.Help,
+			Labels: gauge.Labels,
+		})
+	}
+
+	content, err := dashboard.Generate(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate dashboard: %v", err)
+	}
+
+	if dashboardOutput == "" {
+		_, err := w.Write(content)
+		return err
+	}
+
+	if err := writeFileAtomically(dashboardOutput, content); err != nil {
+		return fmt.Errorf("failed to write dashboard: %v", err)
+	}
+	fmt.Fprintf(w, "generated dashboard to %s\n", dashboardOutput)
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/keep-network/prometheus-sd/internal/election"
+)
+
+// publishedGroup is a target group in the result published by the leader. In
+// addition to the file_sd format it carries the group's source, so followers
+// can tell the adapter which groups disappeared.
+type publishedGroup struct {
+	Source string `json:"source"`
+	fileSDGroup
+}
+
+// newElector creates the elector for the configured election method.
+func newElector() (election.Elector, error) {
+	if config.electionPath == "" {
+		return nil, fmt.Errorf("election path is required")
+	}
+	if config.electionResultFile == "" {
+		return nil, fmt.Errorf("election result file is required")
+	}
+	if config.electionRenewInterval >= config.electionLeaseDuration {
+		return nil, fmt.Errorf(
+			"renew interval [%s] must be shorter than lease duration [%s]",
+			config.electionRenewInterval,
+			config.electionLeaseDuration,
+		)
+	}
+
+	switch config.electionMethod {
+	case "lockfile":
+		return election.NewLockFile(config.electionPath)
+	case "lease":
+		id := config.electionID
+		if id == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get hostname: %v", err)
+			}
+			id = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		return election.NewLease(config.electionPath, id, config.electionLeaseDuration), nil
+	default:
+		return nil, fmt.Errorf("unknown election method: %s", config.electionMethod)
+	}
+}
+
+// setLeader records the outcome of the election and wakes up the discovery
+// loop, so a new leader starts scanning without waiting for the next round.
+func (d *discovery) setLeader(leader bool) {
+	var value int32
+	if leader {
+		value = 1
+	}
+	atomic.StoreInt32(&d.leader, value)
+
+	select {
+	case d.leadershipChanged <- struct{}{}:
+	default:
+	}
+}
+
+// isLeader returns true if the instance is the elected leader.
+func (d *discovery) isLeader() bool {
+	return atomic.LoadInt32(&d.leader) == 1
+}
+
+// publishResult writes the target groups for the followers if the instance
+// is still the leader.
+func (d *discovery) publishResult(tgs []*targetgroup.Group) {
+	if !d.isLeader() {
+		level.Warn(logger).Log("msg", "lost leadership during the round; not publishing targets")
+		return
+	}
+
+	if err := writePublishedResult(config.electionResultFile, tgs); err != nil {
+		level.Error(logger).Log(
+			"msg", "failed to publish targets for followers",
+			"file", config.electionResultFile,
+			"err", err,
+		)
+	}
+}
+
+// followLeader sends the target groups last published by the leader to the
+// adapter if they changed since the last call. It returns false if the
+// context is done.
+func (d *discovery) followLeader() bool {
+	info, err := os.Stat(config.electionResultFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			level.Debug(logger).Log("msg", "leader hasn't published targets yet")
+		} else {
+			level.Error(logger).Log("msg", "failed to check leader's targets", "err", err)
+		}
+		return true
+	}
+	if info.ModTime().Equal(d.followedModTime) {
+		return true
+	}
+
+	tgs, err := readPublishedResult(config.electionResultFile)
+	if err != nil {
+		level.Error(logger).Log(
+			"msg", "failed to read leader's targets",
+			"file", config.electionResultFile,
+			"err", err,
+		)
+		return true
+	}
+	d.followedModTime = info.ModTime()
+
+	level.Info(logger).Log("msg", "following leader's targets", "groups", len(tgs))
+
+	d.setTargets(tgs)
+
+	// Publish own targets once elected, even if they don't differ from the
+	// previous ones.
+	d.forcePublish = true
+
+	return d.stream.Send(d.sources.Complete(tgs, nil))
+}
+
+// writePublishedResult atomically replaces the file with the target groups.
+func writePublishedResult(file string, tgs []*targetgroup.Group) error {
+	groups := make([]publishedGroup, 0, len(tgs))
+	for _, tg := range tgs {
+		group := publishedGroup{
+			Source: tg.Source,
+			fileSDGroup: fileSDGroup{
+				Targets: make([]string, 0, len(tg.Targets)),
+				Labels:  make(map[string]string, len(tg.Labels)),
+			},
+		}
+		for _, target := range tg.Targets {
+			group.Targets = append(group.Targets, string(target[model.AddressLabel]))
+		}
+		for name, value := range tg.Labels {
+			group.Labels[string(name)] = string(value)
+		}
+
+		groups = append(groups, group)
+	}
+
+	b, err := json.MarshalIndent(groups, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(file, b)
+}
+
+// readPublishedResult reads the target groups published by the leader.
+func readPublishedResult(file string) ([]*targetgroup.Group, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []publishedGroup
+	if err := json.Unmarshal(b, &groups); err != nil {
+		return nil, err
+	}
+
+	tgs := make([]*targetgroup.Group, 0, len(groups))
+	for _, group := range groups {
+		tg := &targetgroup.Group{
+			Source:  group.Source,
+			Targets: make([]model.LabelSet, 0, len(group.Targets)),
+			Labels:  make(model.LabelSet, len(group.Labels)),
+		}
+		for _, target := range group.Targets {
+			tg.Targets = append(tg.Targets, model.LabelSet{
+				model.AddressLabel: model.LabelValue(target),
+			})
+		}
+		for name, value := range group.Labels {
+			tg.Labels[model.LabelName(name)] = model.LabelValue(value)
+		}
+
+		tgs = append(tgs, tg)
+	}
+
+	return tgs, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// endpointKindRegexp matches kinds of endpoint paths, which are used in
+// sources of target groups and label values.
+var endpointKindRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// endpointPath is a path served at the peer's endpoint exported as a separate
+// target.
+type endpointPath struct {
+	Kind string
+	Path string
+}
+
+// parseEndpointPaths parses endpoint paths in the <kind>=<path> format.
+func parseEndpointPaths(values []string) ([]endpointPath, error) {
+	paths := make([]endpointPath, 0, len(values))
+	kinds := make(map[string]bool, len(values))
+	for _, value := range values {
+		kind, path, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in the <kind>=<path> format", value)
+		}
+		if !endpointKindRegexp.MatchString(kind) {
+			return nil, fmt.Errorf("invalid kind %q: must match %s", kind, endpointKindRegexp)
+		}
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("path of kind %s must start with /", kind)
+		}
+		if kinds[kind] {
+			return nil, fmt.Errorf("duplicate kind: %s", kind)
+		}
+		kinds[kind] = true
+
+		paths = append(paths, endpointPath{Kind: kind, Path: path})
+	}
+
+	return paths, nil
+}
+
+// checkEndpointPaths sets the peer's endpoint paths to the configured ones
+// responding at its resolved endpoint.
+func (d *discovery) checkEndpointPaths(ctx context.Context, peerLogger log.Logger, peer *peerData) {
+	peer.EndpointPaths = nil
+	if len(d.endpointPaths) == 0 || peer.ClientInfoEndpoint == "" {
+		return
+	}
+
+	host, _, err := net.SplitHostPort(peer.ClientInfoEndpoint)
+	if err != nil {
+		level.Error(peerLogger).Log(
+			"msg", "invalid endpoint",
+			"endpoint", peer.ClientInfoEndpoint,
+			"err", err,
+		)
+		return
+	}
+	client := d.clients.ForPeer(peer.ChainAddress, host)
+
+	for _, path := range d.endpointPaths {
+		if err := client.Check(ctx, peer.ClientInfoEndpoint, path.Path); err != nil {
+			level.Warn(peerLogger).Log(
+				"msg", "endpoint path doesn't respond; not exporting its target",
+				"endpoint", peer.ClientInfoEndpoint,
+				"kind", path.Kind,
+				"path", path.Path,
+				"err", err,
+			)
+			continue
+		}
+
+		peer.EndpointPaths = append(peer.EndpointPaths, path)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+
+	"github.com/go-kit/log/level"
+)
+
+// locatePeers sets labels with locations and autonomous systems of peers
+// found in the GeoIP databases. The IP address the diagnostics have been
+// served from is looked up; if it is unknown, the endpoint's host is looked
+// up if it is an IP address. Peers that can't be located have no labels, so
+// labels of a previous location don't persist.
+func (d *discovery) locatePeers(peers map[string]*peerData) {
+	if d.geoip == nil {
+		return
+	}
+
+	for _, peer := range peers {
+		peer.GeoLabels = nil
+
+		ip := peerIP(peer)
+		if ip == nil {
+			continue
+		}
+
+		labels, err := d.geoip.Labels(ip)
+		if err != nil {
+			level.Warn(logger).Log(
+				"msg", "failed to look up peer in geoip databases",
+				"peer", peer.ChainAddress,
+				"ip", ip,
+				"err", err,
+			)
+			continue
+		}
+
+		peer.GeoLabels = labels
+	}
+}
+
+// peerIP returns the IP address of the peer's diagnostics endpoint or nil if
+// it is not known.
+func peerIP(peer *peerData) net.IP {
+	if peer.EndpointIP != "" {
+		return net.ParseIP(peer.EndpointIP)
+	}
+
+	host, _, err := net.SplitHostPort(peer.ClientInfoEndpoint)
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLocatePeers(t *testing.T) {
+	d, _ := newTestDiscovery(
+		t,
+		"--geoip.cityDatabase=internal/geoip/testdata/GeoLite2-City-Test.mmdb",
+		"--geoip.asnDatabase=internal/geoip/testdata/GeoLite2-ASN-Test.mmdb",
+	)
+	defer d.geoip.Close()
+
+	var tests = map[string]struct {
+		move func(peer *peerData)
+	}{
+		"address missing in databases": {
+			move: func(peer *peerData) { peer.ClientInfoEndpoint = "34.141.9.57:9601" },
+		},
+		"hostname endpoint": {
+			move: func(peer *peerData) { peer.ClientInfoEndpoint = "peer-a.example:9601" },
+		},
+		"unresolved": {
+			move: func(peer *peerData) { peer.clearResolution() },
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			peer := resolvedPeer("0xA", "81.2.69.160:9601")
+			peers := map[string]*peerData{peer.ChainAddress: peer}
+
+			d.locatePeers(peers)
+			if country := peer.GeoLabels["__meta_keep_geo_country"]; country != "GB" {
+				t.Fatalf("unexpected country: %s", country)
+			}
+
+			// The peer loses the labels of its previous location.
+			test.move(peer)
+			d.locatePeers(peers)
+			if len(peer.GeoLabels) != 0 {
+				t.Errorf("unexpected geo labels: %v", peer.GeoLabels)
+			}
+		})
+	}
+
+	t.Run("failed lookup", func(t *testing.T) {
+		peer := resolvedPeer("0xA", "81.2.69.160:9601")
+		peers := map[string]*peerData{peer.ChainAddress: peer}
+
+		d.locatePeers(peers)
+
+		// Lookups in closed databases fail.
+		d.geoip.Close()
+		d.locatePeers(peers)
+		if len(peer.GeoLabels) != 0 {
+			t.Errorf("unexpected geo labels: %v", peer.GeoLabels)
+		}
+	})
+}
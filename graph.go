@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-kit/log"
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+
+	"github.com/keep-network/prometheus-sd/internal/graph"
+)
+
+var (
+	graphCmd = app.Command("graph", "Print the connectivity graph of the network.")
+
+	graphFormat       string
+	graphResolvePeers bool
+)
+
+func init() {
+	graphCmd.Flag(
+		"graph.format",
+		"Output format: dot, graphml, json or stats.",
+	).Default("dot").EnumVar(&graphFormat, "dot", "graphml", "json", "stats")
+
+	graphCmd.Flag(
+		"graph.resolvePeers",
+		"Resolve diagnostics endpoints of peers to include their own connections. Otherwise only connections reported by the sources are included.",
+	).Default("false").BoolVar(&graphResolvePeers)
+}
+
+// connectedPeers returns chain addresses of peers connected to the node.
+func connectedPeers(diagnostics clientinfo.Diagnostics) []string {
+	chainAddresses := make([]string, 0, len(diagnostics.ConnectedPeers))
+	for _, peer := range diagnostics.ConnectedPeers {
+		chainAddresses = append(chainAddresses, peer.ChainAddress)
+	}
+	return chainAddresses
+}
+
+// buildGraph builds the connectivity graph from connections reported by the
+// sources and by the peers' own diagnostics.
+func buildGraph(sourceDiagnostics []clientinfo.Diagnostics, peers map[string]*peerData) *graph.Graph {
+	g := graph.New()
+
+	for _, diagnostics := range sourceDiagnostics {
+		g.AddNode(diagnostics.ClientInfo.ChainAddress)
+		for _, connectedPeer := range connectedPeers(diagnostics) {
+			g.AddEdge(diagnostics.ClientInfo.ChainAddress, connectedPeer)
+		}
+	}
+
+	for _, peer := range peers {
+		g.AddNode(peer.ChainAddress)
+		for _, connectedPeer := range peer.ConnectedPeers {
+			g.AddEdge(peer.ChainAddress, connectedPeer)
+		}
+	}
+
+	return g
+}
+
+func (d *discovery) setGraph(g *graph.Graph) {
+	d.graphMutex.Lock()
+	defer d.graphMutex.Unlock()
+
+	d.graph = g
+}
+
+// Graph returns the connectivity graph of the last completed discovery round
+// or nil if no round has been completed yet.
+func (d *discovery) Graph() *graph.Graph {
+	d.graphMutex.RLock()
+	defer d.graphMutex.RUnlock()
+
+	return d.graph
+}
+
+var graphContentTypes = map[string]string{
+	"dot":     "text/vnd.graphviz; charset=utf-8",
+	"graphml": "application/graphml+xml; charset=utf-8",
+	"json":    "application/json",
+}
+
+// serveGraph serves the connectivity graph in the format set with the format
+// query parameter: dot, graphml or json (default).
+func (d *discovery) serveGraph(w http.ResponseWriter, r *http.Request) {
+	g := d.Graph()
+	if g == nil {
+		http.Error(w, "no discovery round completed", http.StatusServiceUnavailable)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := graphContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	g.Write(w, format)
+}
+
+// serveGraphStats serves the summary of the connectivity graph.
+func (d *discovery) serveGraphStats(w http.ResponseWriter, r *http.Request) {
+	g := d.Graph()
+	if g == nil {
+		http.Error(w, "no discovery round completed", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(g.Stats())
+}
+
+// runGraph collects diagnostics from the sources, optionally resolves the
+// peers, and prints the connectivity graph.
+func runGraph(ctx context.Context, w io.Writer) error {
+	disc, err := newDiscovery()
+	if err != nil {
+		return fmt.Errorf("failed to initiate discovery: %v", err)
+	}
+
+	sourceDiagnostics := disc.collectDiagnostics(ctx, config.listenAddresses)
+	if len(sourceDiagnostics) == 0 {
+		return fmt.Errorf("failed to collect diagnostics from any source")
+	}
+
+	peers, _ := disc.combineDiscoveredPeers(sourceDiagnostics)
+
+	if graphResolvePeers {
+		discoveredPorts := make(map[string]map[string]int)
+		for _, peer := range peers {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			disc.resolvePeer(ctx, log.With(logger, "peer", peer.ChainAddress), peer, discoveredPorts)
+		}
+	}
+
+	g := buildGraph(sourceDiagnostics, peers)
+
+	if graphFormat == "stats" {
+		return printGraphStats(w, g.Stats())
+	}
+
+	return g.Write(w, graphFormat)
+}
+
+func printGraphStats(w io.Writer, stats graph.Stats) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "nodes\t%d\n", stats.Nodes)
+	fmt.Fprintf(tw, "edges\t%d\n", stats.Edges)
+	fmt.Fprintf(tw, "average degree\t%.2f\n", stats.AverageDegree)
+	fmt.Fprintf(tw, "max degree\t%d\n", stats.MaxDegree)
+	fmt.Fprintf(tw, "isolated nodes\t%s\n", strings.Join(stats.IsolatedNodes, ", "))
+	fmt.Fprintf(tw, "components\t%d\n", stats.Components)
+
+	degrees := make([]int, 0, len(stats.DegreeDistribution))
+	for degree := range stats.DegreeDistribution {
+		degrees = append(degrees, degree)
+	}
+	sort.Ints(degrees)
+
+	fmt.Fprintf(tw, "\nDEGREE\tNODES\n")
+	for _, degree := range degrees {
+		fmt.Fprintf(tw, "%d\t%d\n", degree, stats.DegreeDistribution[degree])
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+
+	"github.com/keep-network/prometheus-sd/internal/graph"
+	"github.com/keep-network/prometheus-sd/internal/health"
+)
+
+// unknownVersion labels peers whose client version is not known, because
+// their diagnostics endpoint has not been resolved.
+const unknownVersion = "unknown"
+
+// healthSnapshot describes the network at the end of a discovery round.
+func healthSnapshot(
+	sourceDiagnostics []clientinfo.Diagnostics,
+	peers map[string]*peerData,
+	networkIDConflicts []string,
+	connectivityGraph *graph.Graph,
+) health.Snapshot {
+	snapshot := health.Snapshot{
+		Time:               time.Now(),
+		Sources:            len(config.listenAddresses),
+		SourcePeers:        make(map[string]int, len(sourceDiagnostics)),
+		Peers:              len(peers),
+		NetworkIDConflicts: len(networkIDConflicts),
+		Versions:           make(map[string]int),
+	}
+
+	for _, diagnostics := range sourceDiagnostics {
+		snapshot.SourcePeers[diagnostics.ClientInfo.ChainAddress] = len(diagnostics.ConnectedPeers)
+	}
+
+	networkIDs := make(map[string]struct{}, len(peers))
+	for _, peer := range peers {
+		if peer.NetworkID != "" {
+			networkIDs[peer.NetworkID] = struct{}{}
+		}
+
+		if peer.ClientInfoEndpoint == "" {
+			snapshot.UnresolvedPeers++
+		}
+
+		version := peer.Version
+		if version == "" {
+			version = unknownVersion
+		}
+		snapshot.Versions[version]++
+	}
+	snapshot.NetworkIDs = len(networkIDs)
+
+	stats := connectivityGraph.Stats()
+	snapshot.IsolatedPeers = len(stats.IsolatedNodes)
+	for _, component := range connectivityGraph.Components() {
+		snapshot.ComponentSizes = append(snapshot.ComponentSizes, len(component))
+	}
+
+	return snapshot
+}
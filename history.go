@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keep-network/prometheus-sd/internal/history"
+)
+
+var (
+	historyCmd        = app.Command("history", "Query the peer history recorded by the discovery.")
+	historyPeersCmd   = historyCmd.Command("peers", "List all recorded peers.")
+	historyChurnCmd   = historyCmd.Command("churn", "List peers that joined or left the network.")
+	historyUptimeCmd  = historyCmd.Command("uptime", "Show the percentage of discovery rounds a peer has been seen in.")
+	historyChangesCmd = historyCmd.Command("changes", "Show the address, endpoint and version change history of a peer.")
+
+	historySince        time.Duration
+	historyChainAddress string
+)
+
+func init() {
+	historyCmd.Flag(
+		"history.since",
+		"Time window to query the history for.",
+	).Default("168h").DurationVar(&historySince)
+
+	historyUptimeCmd.Arg(
+		"chain-address",
+		"Chain address of the peer.",
+	).Required().StringVar(&historyChainAddress)
+
+	historyChangesCmd.Arg(
+		"chain-address",
+		"Chain address of the peer.",
+	).Required().StringVar(&historyChainAddress)
+}
+
+// recordHistory records the peers discovered in the round in the history
+// database. The database is opened only for the time of recording, so it
+// can be queried with the history command while the discovery is running.
+func recordHistory(file string, at time.Time, peers map[string]*peerData) error {
+	store, err := history.Open(file, false)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	observations := make([]history.Observation, 0, len(peers))
+	for _, peer := range peers {
+		observations = append(observations, history.Observation{
+			ChainAddress:     peer.ChainAddress,
+			NetworkID:        peer.NetworkID,
+			NetworkAddresses: peer.NetworkAddresses,
+			Endpoint:         peer.ClientInfoEndpoint,
+			Version:          peer.Version,
+		})
+	}
+
+	return store.RecordRound(at, observations)
+}
+
+func openHistory() (*history.Store, error) {
+	if config.historyFile == "" {
+		return nil, fmt.Errorf("history file is not configured")
+	}
+
+	return history.Open(config.historyFile, true)
+}
+
+func runHistoryPeers(w io.Writer) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	peers, err := store.Peers()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHAIN ADDRESS\tFIRST SEEN\tLAST SEEN")
+	for _, peer := range peers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", peer.ChainAddress, formatTime(peer.FirstSeen), formatTime(peer.LastSeen))
+	}
+	return tw.Flush()
+}
+
+func runHistoryChurn(w io.Writer) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	to := time.Now()
+	report, err := store.Churn(to.Add(-historySince), to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		w,
+		"%d peers joined and %d peers left between %s and %s (%d rounds)\n",
+		len(report.Joined),
+		len(report.Left),
+		formatTime(report.From),
+		formatTime(report.To),
+		report.Rounds,
+	)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nCHANGE\tCHAIN ADDRESS\tFIRST SEEN\tLAST SEEN")
+	for _, peer := range report.Joined {
+		fmt.Fprintf(tw, "joined\t%s\t%s\t%s\n", peer.ChainAddress, formatTime(peer.FirstSeen), formatTime(peer.LastSeen))
+	}
+	for _, peer := range report.Left {
+		fmt.Fprintf(tw, "left\t%s\t%s\t%s\n", peer.ChainAddress, formatTime(peer.FirstSeen), formatTime(peer.LastSeen))
+	}
+	return tw.Flush()
+}
+
+func runHistoryUptime(w io.Writer) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	to := time.Now()
+	uptime, err := store.Uptime(historyChainAddress, to.Add(-historySince), to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "%s uptime in the last %s: %.2f%%\n", historyChainAddress, historySince, uptime)
+	return nil
+}
+
+func runHistoryChanges(w io.Writer) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	changes, err := store.Changes(historyChainAddress)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tNETWORK ID\tNETWORK ADDRESSES\tENDPOINT\tVERSION")
+	for _, change := range changes {
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\n",
+			formatTime(change.Time),
+			change.NetworkID,
+			strings.Join(change.NetworkAddresses, ","),
+			change.Endpoint,
+			change.Version,
+		)
+	}
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
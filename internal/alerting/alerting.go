@@ -0,0 +1,169 @@
+// Package alerting pushes alerts to Alertmanager-compatible APIs.
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertsPath is the path of the Alertmanager API v2 endpoint receiving alerts.
+const alertsPath = "/api/v2/alerts"
+
+// Alert is an alert in the Alertmanager API v2 format.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// key identifies the alert by its labels.
+func (a Alert) key() string {
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, a.Labels[name])
+	}
+	return b.String()
+}
+
+// Client posts alerts to Alertmanager instances.
+type Client struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewClient creates a client for Alertmanager instances under the base URLs.
+func NewClient(urls []string, timeout time.Duration) *Client {
+	return &Client{
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Send posts the alerts to all Alertmanager instances. It returns an error
+// if any of the instances failed to receive the alerts.
+func (c *Client) Send(ctx context.Context, alerts []Alert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to encode alerts: %w", err)
+	}
+
+	errs := make([]string, 0)
+	for _, url := range c.urls {
+		if err := c.post(ctx, strings.TrimSuffix(url, "/")+alertsPath, body); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send alerts: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Notifier keeps track of firing alerts. Alerts firing in consecutive
+// evaluations are re-sent with their original start time and alerts that
+// stopped firing are sent as resolved.
+type Notifier struct {
+	client *Client
+
+	// Time after which Alertmanager considers a firing alert resolved if it
+	// is not re-sent.
+	ttl time.Duration
+
+	mutex  sync.Mutex
+	active map[string]Alert
+}
+
+// NewNotifier creates a notifier sending alerts with the client.
+func NewNotifier(client *Client, ttl time.Duration) *Notifier {
+	return &Notifier{
+		client: client,
+		ttl:    ttl,
+		active: make(map[string]Alert),
+	}
+}
+
+// Notify sends the currently firing alerts and resolves the previously
+// firing alerts that are not firing anymore.
+func (n *Notifier) Notify(ctx context.Context, firing []Alert, now time.Time) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	alerts := make([]Alert, 0, len(firing))
+	active := make(map[string]Alert, len(firing))
+
+	for _, alert := range firing {
+		key := alert.key()
+		if previous, ok := n.active[key]; ok {
+			alert.StartsAt = previous.StartsAt
+		} else {
+			alert.StartsAt = now
+		}
+		alert.EndsAt = now.Add(n.ttl)
+
+		active[key] = alert
+		alerts = append(alerts, alert)
+	}
+
+	for key, alert := range n.active {
+		if _, ok := active[key]; !ok {
+			alert.EndsAt = now
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	if err := n.client.Send(ctx, alerts); err != nil {
+		// Keep the previous state, so resolved alerts are sent again in the
+		// next evaluation.
+		for key, alert := range n.active {
+			if _, ok := active[key]; !ok {
+				active[key] = alert
+			}
+		}
+		n.active = active
+		return err
+	}
+
+	n.active = active
+	return nil
+}
@@ -0,0 +1,101 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeAlertmanager records alerts posted to the Alertmanager API.
+type fakeAlertmanager struct {
+	requests [][]Alert
+}
+
+func (f *fakeAlertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != alertsPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var alerts []Alert
+	if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests = append(f.requests, alerts)
+}
+
+func TestNotifier(t *testing.T) {
+	alertmanager := &fakeAlertmanager{}
+	server := httptest.NewServer(alertmanager)
+	defer server.Close()
+
+	notifier := NewNotifier(NewClient([]string{server.URL}, time.Second), 15*time.Minute)
+
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	alert := Alert{Labels: map[string]string{"alertname": "KeepSDSourcesUnreachable"}}
+
+	evaluations := []struct {
+		firing            []Alert
+		expectedAlerts    int
+		expectedStartsAt  time.Time
+		expectedEndsAt    time.Time
+		expectedRequested bool
+	}{
+		// The alert starts firing.
+		{firing: []Alert{alert}, expectedAlerts: 1, expectedStartsAt: start, expectedEndsAt: start.Add(15 * time.Minute), expectedRequested: true},
+		// The alert is still firing and keeps its start time.
+		{firing: []Alert{alert}, expectedAlerts: 1, expectedStartsAt: start, expectedEndsAt: start.Add(20 * time.Minute), expectedRequested: true},
+		// The alert is resolved.
+		{firing: nil, expectedAlerts: 1, expectedStartsAt: start, expectedEndsAt: start.Add(10 * time.Minute), expectedRequested: true},
+		// Nothing to send.
+		{firing: nil, expectedRequested: false},
+	}
+
+	for i, evaluation := range evaluations {
+		requests := len(alertmanager.requests)
+
+		now := start.Add(time.Duration(i) * 5 * time.Minute)
+		if err := notifier.Notify(context.Background(), evaluation.firing, now); err != nil {
+			t.Fatalf("evaluation %d: unexpected error: %v", i, err)
+		}
+
+		if !evaluation.expectedRequested {
+			if len(alertmanager.requests) != requests {
+				t.Errorf("evaluation %d: unexpected request", i)
+			}
+			continue
+		}
+
+		if len(alertmanager.requests) != requests+1 {
+			t.Fatalf("evaluation %d: expected request", i)
+		}
+
+		alerts := alertmanager.requests[requests]
+		if len(alerts) != evaluation.expectedAlerts {
+			t.Fatalf("evaluation %d: invalid alerts number\nexpected: %d\nactual:   %d", i, evaluation.expectedAlerts, len(alerts))
+		}
+		if !alerts[0].StartsAt.Equal(evaluation.expectedStartsAt) {
+			t.Errorf("evaluation %d: invalid start\nexpected: %v\nactual:   %v", i, evaluation.expectedStartsAt, alerts[0].StartsAt)
+		}
+		if !alerts[0].EndsAt.Equal(evaluation.expectedEndsAt) {
+			t.Errorf("evaluation %d: invalid end\nexpected: %v\nactual:   %v", i, evaluation.expectedEndsAt, alerts[0].EndsAt)
+		}
+	}
+}
+
+func TestClientSendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := NewClient([]string{server.URL}, time.Second)
+
+	if err := client.Send(context.Background(), []Alert{{Labels: map[string]string{"alertname": "test"}}}); err == nil {
+		t.Errorf("expected error")
+	}
+}
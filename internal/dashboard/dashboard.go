@@ -0,0 +1,417 @@
+// Package dashboard generates a Grafana dashboard for the targets exported by
+// the discovery and for the discovery's own metrics.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	datasourceVariable = "datasource"
+	nodesJobVariable   = "job"
+	sdJobVariable      = "sd_job"
+
+	// allValue matches any value of a label, including a missing one.
+	allValue = ".*"
+
+	panelWidth  = 12
+	panelHeight = 8
+	gridWidth   = 24
+)
+
+// Metric describes a gauge exported by the discovery.
+type Metric struct {
+	Name   string
+	Help   string
+	Labels []string
+}
+
+// Config tunes the generated dashboard.
+type Config struct {
+	Title string
+	UID   string
+
+	// NodesJob is the name of the job scraping the discovered nodes.
+	NodesJob string
+	// SDJob is the name of the job scraping the discovery's own metrics.
+	SDJob string
+
+	// EndpointKindLabel is the target label holding the kinds of the nodes'
+	// targets if peers have a target per endpoint path.
+	EndpointKindLabel string
+	// EndpointKind is the kind of the nodes' targets shown in the panels, so
+	// each peer counts once. Targets without a kind, exported for peers with a
+	// single target, are always shown. All targets are shown if empty.
+	EndpointKind string
+
+	// Labels are the target labels of the discovered nodes. Each of them
+	// becomes a template variable filtering the nodes' panels.
+	Labels []string
+	// GroupBy are the labels the nodes are counted by, each in a panel.
+	GroupBy []string
+	// Metrics are the discovery's own metrics, each shown in a panel.
+	Metrics []Metric
+}
+
+type dashboard struct {
+	UID           string     `json:"uid,omitempty"`
+	Title         string     `json:"title"`
+	Tags          []string   `json:"tags"`
+	Editable      bool       `json:"editable"`
+	GraphTooltip  int        `json:"graphTooltip"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []*panel   `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name        string      `json:"name"`
+	Label       string      `json:"label,omitempty"`
+	Type        string      `json:"type"`
+	Datasource  *datasource `json:"datasource,omitempty"`
+	Query       interface{} `json:"query"`
+	Definition  string      `json:"definition,omitempty"`
+	Current     *option     `json:"current,omitempty"`
+	Options     []option    `json:"options,omitempty"`
+	Multi       bool        `json:"multi"`
+	IncludeAll  bool        `json:"includeAll"`
+	AllValue    string      `json:"allValue,omitempty"`
+	Refresh     int         `json:"refresh,omitempty"`
+	Sort        int         `json:"sort,omitempty"`
+	Hide        int         `json:"hide"`
+	SkipURLSync bool        `json:"skipUrlSync"`
+}
+
+type variableQuery struct {
+	Query string `json:"query"`
+	RefID string `json:"refId"`
+}
+
+type option struct {
+	Selected bool   `json:"selected"`
+	Text     string `json:"text"`
+	Value    string `json:"value"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	GridPos     gridPos      `json:"gridPos"`
+	Datasource  *datasource  `json:"datasource,omitempty"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+	Targets     []target     `json:"targets,omitempty"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type fieldConfig struct {
+	Defaults  fieldDefaults `json:"defaults"`
+	Overrides []struct{}    `json:"overrides"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+	Min  *int   `json:"min,omitempty"`
+}
+
+type target struct {
+	Datasource   *datasource `json:"datasource"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat,omitempty"`
+	RefID        string      `json:"refId"`
+}
+
+// layout places panels on the dashboard's grid, row by row.
+type layout struct {
+	panels []*panel
+	nextID int
+	x, y   int
+}
+
+func (l *layout) row(title string) {
+	if l.x > 0 {
+		l.x = 0
+		l.y += panelHeight
+	}
+
+	l.nextID++
+	l.panels = append(l.panels, &panel{
+		ID:      l.nextID,
+		Type:    "row",
+		Title:   title,
+		GridPos: gridPos{H: 1, W: gridWidth, X: 0, Y: l.y},
+	})
+	l.y++
+}
+
+func (l *layout) add(p *panel) {
+	l.nextID++
+	p.ID = l.nextID
+	p.GridPos = gridPos{H: panelHeight, W: panelWidth, X: l.x, Y: l.y}
+	l.panels = append(l.panels, p)
+
+	l.x += panelWidth
+	if l.x >= gridWidth {
+		l.x = 0
+		l.y += panelHeight
+	}
+}
+
+// Generate returns the dashboard in the Grafana JSON model.
+func Generate(cfg Config) ([]byte, error) {
+	if cfg.Title == "" {
+		return nil, fmt.Errorf("title is required")
+	}
+	if cfg.NodesJob == "" || cfg.SDJob == "" {
+		return nil, fmt.Errorf("job names are required")
+	}
+
+	labels := make(map[string]bool, len(cfg.Labels))
+	for _, label := range cfg.Labels {
+		if !model.LabelName(label).IsValid() || strings.HasPrefix(label, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid label name: %q", label)
+		}
+		if labels[label] {
+			return nil, fmt.Errorf("duplicate label: %s", label)
+		}
+		labels[label] = true
+	}
+	for _, label := range cfg.GroupBy {
+		if !labels[label] {
+			return nil, fmt.Errorf("grouping label %s is not among the labels", label)
+		}
+	}
+	if cfg.EndpointKind != "" {
+		if !model.LabelName(cfg.EndpointKindLabel).IsValid() {
+			return nil, fmt.Errorf("invalid endpoint kind label name: %q", cfg.EndpointKindLabel)
+		}
+		// The panels show a single kind, so it can't be chosen.
+		if labels[cfg.EndpointKindLabel] {
+			return nil, fmt.Errorf("endpoint kind label %s is among the labels", cfg.EndpointKindLabel)
+		}
+	}
+
+	ds := &datasource{Type: "prometheus", UID: "${" + datasourceVariable + "}"}
+
+	variables := []variable{
+		{
+			Name:  datasourceVariable,
+			Label: "Data source",
+			Type:  "datasource",
+			Query: "prometheus",
+		},
+		constantVariable(nodesJobVariable, "Nodes job", cfg.NodesJob),
+		constantVariable(sdJobVariable, "Discovery job", cfg.SDJob),
+	}
+
+	matchers := []string{fmt.Sprintf(`job="$%s"`, nodesJobVariable)}
+	for i, label := range cfg.Labels {
+		query := fmt.Sprintf(`label_values(up{job="$%s"}, %s)`, nodesJobVariable, label)
+		variables = append(variables, variable{
+			Name:       label,
+			Label:      title(label),
+			Type:       "query",
+			Datasource: ds,
+			Query:      variableQuery{Query: query, RefID: fmt.Sprintf("Variable%d", i)},
+			Definition: query,
+			Current:    &option{Selected: true, Text: "All", Value: "$__all"},
+			Multi:      true,
+			IncludeAll: true,
+			AllValue:   allValue,
+			Refresh:    2,
+			Sort:       1,
+		})
+
+		matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, label, label))
+	}
+	if cfg.EndpointKind != "" {
+		matchers = append(matchers, fmt.Sprintf(
+			"%s=~%q",
+			cfg.EndpointKindLabel,
+			"|"+regexp.QuoteMeta(cfg.EndpointKind),
+		))
+	}
+	nodes := fmt.Sprintf("up{%s}", strings.Join(matchers, ", "))
+
+	l := &layout{}
+
+	l.row("Nodes")
+	l.add(&panel{
+		Type:        "timeseries",
+		Title:       "Peers",
+		Description: "Number of discovered peers matching the filters, by whether they are scraped successfully.",
+		Datasource:  ds,
+		FieldConfig: countFieldConfig(),
+		Targets: []target{
+			{Datasource: ds, Expr: fmt.Sprintf("count(%s == 1)", nodes), LegendFormat: "up", RefID: "A"},
+			{Datasource: ds, Expr: fmt.Sprintf("count(%s == 0)", nodes), LegendFormat: "down", RefID: "B"},
+		},
+	})
+	for _, label := range cfg.GroupBy {
+		l.add(&panel{
+			Type:        "timeseries",
+			Title:       "Peers up by " + words(label),
+			Description: fmt.Sprintf("Number of peers matching the filters and scraped successfully, by the %s label.", label),
+			Datasource:  ds,
+			FieldConfig: countFieldConfig(),
+			Targets: []target{
+				{
+					Datasource:   ds,
+					Expr:         fmt.Sprintf("count by (%s) (%s == 1)", label, nodes),
+					LegendFormat: fmt.Sprintf("{{%s}}", label),
+					RefID:        "A",
+				},
+			},
+		})
+	}
+	// Metrics exposed by the peers themselves.
+	for _, metric := range []struct{ name, title string }{
+		{"connected_peers_count", "Connected peers"},
+		{"connected_bootstrap_count", "Connected bootstraps"},
+	} {
+		l.add(&panel{
+			Type:        "timeseries",
+			Title:       metric.title,
+			Description: fmt.Sprintf("The %s metric of the peers matching the filters.", metric.name),
+			Datasource:  ds,
+			FieldConfig: countFieldConfig(),
+			Targets: []target{
+				{
+					Datasource: ds,
+					Expr: fmt.Sprintf(
+						`%s{job="$%s"} and on (instance) %s`,
+						metric.name,
+						nodesJobVariable,
+						nodes,
+					),
+					LegendFormat: "{{chain_address}} ({{instance}})",
+					RefID:        "A",
+				},
+			},
+		})
+	}
+
+	l.row("Discovery")
+	for _, metric := range cfg.Metrics {
+		l.add(metricPanel(ds, metric))
+	}
+
+	b, err := json.MarshalIndent(dashboard{
+		UID:           cfg.UID,
+		Title:         cfg.Title,
+		Tags:          []string{"keep-network", "prometheus-sd"},
+		Editable:      true,
+		GraphTooltip:  1,
+		Refresh:       "1m",
+		SchemaVersion: 37,
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Templating:    templating{List: variables},
+		Panels:        l.panels,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(b, '\n'), nil
+}
+
+// metricPanel returns a panel showing the discovery's metric. Timestamps are
+// shown as the time elapsed since them.
+func metricPanel(ds *datasource, metric Metric) *panel {
+	selector := fmt.Sprintf(`%s{job="$%s"}`, metric.Name, sdJobVariable)
+
+	legend := make([]string, 0, len(metric.Labels)+1)
+	for _, label := range metric.Labels {
+		legend = append(legend, fmt.Sprintf("{{%s}}", label))
+	}
+	legend = append(legend, "({{instance}})")
+
+	p := &panel{
+		Type:        "timeseries",
+		Title:       metric.Name,
+		Description: metric.Help,
+		Datasource:  ds,
+		FieldConfig: countFieldConfig(),
+		Targets: []target{
+			{
+				Datasource:   ds,
+				Expr:         selector,
+				LegendFormat: strings.Join(legend, " "),
+				RefID:        "A",
+			},
+		},
+	}
+
+	if strings.HasSuffix(metric.Name, "_timestamp_seconds") {
+		p.Title = "Time since " + metric.Name
+		p.FieldConfig = &fieldConfig{Defaults: fieldDefaults{Unit: "s"}}
+		p.Targets[0].Expr = "time() - " + selector
+	}
+
+	return p
+}
+
+// constantVariable returns a variable holding a single value, which can be
+// changed if the dashboard is imported to a different setup.
+func constantVariable(name, label, value string) variable {
+	return variable{
+		Name:    name,
+		Label:   label,
+		Type:    "custom",
+		Query:   value,
+		Current: &option{Selected: true, Text: value, Value: value},
+		Options: []option{{Selected: true, Text: value, Value: value}},
+		Hide:    2,
+	}
+}
+
+func countFieldConfig() *fieldConfig {
+	min := 0
+	return &fieldConfig{Defaults: fieldDefaults{Min: &min}}
+}
+
+// words returns the label name in a human-readable form.
+func words(label string) string {
+	words := strings.Split(label, "_")
+	for i, word := range words {
+		switch word {
+		case "id", "asn":
+			words[i] = strings.ToUpper(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// title returns the label name in a human-readable form, capitalized.
+func title(label string) string {
+	s := words(label)
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,195 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+var testConfig = Config{
+	Title:    "Keep Network Nodes",
+	UID:      "keep-network-nodes",
+	NodesJob: "keep-network-nodes",
+	SDJob:    "keep-sd",
+	Labels:   []string{"chain_address", "network_id", "version", "geo_country"},
+	GroupBy:  []string{"version", "geo_country"},
+	Metrics: []Metric{
+		{Name: "keep_sd_last_update_timestamp_seconds", Help: "Unix time of the last completed discovery pass."},
+		{Name: "keep_sd_version_peers", Help: "Number of peers running a client version.", Labels: []string{"version"}},
+	},
+}
+
+func TestGenerate(t *testing.T) {
+	content, err := Generate(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d dashboard
+	if err := json.Unmarshal(content, &d); err != nil {
+		t.Fatalf("invalid dashboard: %v", err)
+	}
+
+	names := make([]string, 0, len(d.Templating.List))
+	for _, v := range d.Templating.List {
+		names = append(names, v.Name)
+	}
+	expectedNames := []string{
+		"datasource",
+		"job",
+		"sd_job",
+		"chain_address",
+		"network_id",
+		"version",
+		"geo_country",
+	}
+	if strings.Join(names, ",") != strings.Join(expectedNames, ",") {
+		t.Errorf("unexpected variables\nexpected: %v\nactual:   %v", expectedNames, names)
+	}
+
+	titles := make([]string, 0, len(d.Panels))
+	ids := make(map[int]bool, len(d.Panels))
+	for _, p := range d.Panels {
+		titles = append(titles, p.Title)
+
+		if ids[p.ID] {
+			t.Errorf("duplicate panel ID: %d", p.ID)
+		}
+		ids[p.ID] = true
+
+		for _, target := range p.Targets {
+			if _, err := parser.ParseExpr(target.Expr); err != nil {
+				t.Errorf("invalid expression of panel %q: %v\n%s", p.Title, err, target.Expr)
+			}
+		}
+	}
+	expectedTitles := []string{
+		"Nodes",
+		"Peers",
+		"Peers up by version",
+		"Peers up by geo country",
+		"Connected peers",
+		"Connected bootstraps",
+		"Discovery",
+		"Time since keep_sd_last_update_timestamp_seconds",
+		"keep_sd_version_peers",
+	}
+	if strings.Join(titles, ",") != strings.Join(expectedTitles, ",") {
+		t.Errorf("unexpected panels\nexpected: %v\nactual:   %v", expectedTitles, titles)
+	}
+
+	for _, fragment := range []string{
+		`label_values(up{job=\"$job\"}, network_id)`,
+		`count by (version) (up{job=\"$job\", chain_address=~\"$chain_address\", network_id=~\"$network_id\", version=~\"$version\", geo_country=~\"$geo_country\"} == 1)`,
+		`time() - keep_sd_last_update_timestamp_seconds{job=\"$sd_job\"}`,
+		`"legendFormat": "{{version}} ({{instance}})"`,
+	} {
+		if !strings.Contains(string(content), fragment) {
+			t.Errorf("dashboard doesn't contain %q:\n%s", fragment, content)
+		}
+	}
+}
+
+func TestGenerateEndpointKind(t *testing.T) {
+	cfg := testConfig
+	cfg.EndpointKindLabel = "endpoint_kind"
+	cfg.EndpointKind = "metrics"
+
+	content, err := Generate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d dashboard
+	if err := json.Unmarshal(content, &d); err != nil {
+		t.Fatalf("invalid dashboard: %v", err)
+	}
+
+	// Each selector of the nodes' targets keeps targets of the kind and ones
+	// without a kind.
+	selectors := 0
+	for _, p := range d.Panels {
+		for _, target := range p.Targets {
+			expr, err := parser.ParseExpr(target.Expr)
+			if err != nil {
+				t.Fatalf("invalid expression of panel %q: %v", p.Title, err)
+			}
+
+			parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+				selector, ok := node.(*parser.VectorSelector)
+				if !ok || selector.Name != "up" {
+					return nil
+				}
+				selectors++
+
+				for _, matcher := range selector.LabelMatchers {
+					if matcher.Name == "endpoint_kind" &&
+						matcher.Matches("metrics") &&
+						matcher.Matches("") &&
+						!matcher.Matches("diagnostics") {
+						return nil
+					}
+				}
+				t.Errorf("nodes of panel %q not filtered by endpoint kind: %s", p.Title, selector)
+				return nil
+			})
+		}
+	}
+	if selectors == 0 {
+		t.Error("no selectors of the nodes' targets")
+	}
+}
+
+func TestGenerateLayout(t *testing.T) {
+	content, err := Generate(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var d dashboard
+	if err := json.Unmarshal(content, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	// Panels must not overlap.
+	for i, a := range d.Panels {
+		for _, b := range d.Panels[i+1:] {
+			if a.GridPos.X < b.GridPos.X+b.GridPos.W &&
+				b.GridPos.X < a.GridPos.X+a.GridPos.W &&
+				a.GridPos.Y < b.GridPos.Y+b.GridPos.H &&
+				b.GridPos.Y < a.GridPos.Y+a.GridPos.H {
+				t.Errorf("panels %q and %q overlap", a.Title, b.Title)
+			}
+		}
+	}
+}
+
+func TestGenerateInvalidConfig(t *testing.T) {
+	tests := map[string]func(cfg *Config){
+		"missing title":          func(cfg *Config) { cfg.Title = "" },
+		"missing job":            func(cfg *Config) { cfg.SDJob = "" },
+		"invalid label":          func(cfg *Config) { cfg.Labels = append([]string{"geo-country"}, cfg.Labels...) },
+		"meta label":             func(cfg *Config) { cfg.Labels = append([]string{"__meta_keep_version"}, cfg.Labels...) },
+		"duplicate label":        func(cfg *Config) { cfg.Labels = append([]string{"version"}, cfg.Labels...) },
+		"unknown grouping label": func(cfg *Config) { cfg.GroupBy = []string{"operator_name"} },
+		"endpoint kind variable": func(cfg *Config) {
+			cfg.Labels = append([]string{"endpoint_kind"}, cfg.Labels...)
+			cfg.EndpointKindLabel = "endpoint_kind"
+			cfg.EndpointKind = "metrics"
+		},
+		"missing endpoint kind label": func(cfg *Config) { cfg.EndpointKind = "metrics" },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			cfg := testConfig
+			modify(&cfg)
+
+			if _, err := Generate(cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+// Package diagnostics implements clients calling diagnostics endpoints of
+// Keep Network nodes.
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+	config_util "github.com/prometheus/common/config"
+
+	"github.com/keep-network/prometheus-sd/internal/utils"
+)
+
+// Options tune clients regardless of their configuration.
+type Options struct {
+	// Timeout limits the duration of a single call.
+	Timeout time.Duration
+	// MaxBodySize limits the size of a response body in bytes.
+	MaxBodySize int64
+	// IdleConnTimeout is the time after which an idle keep-alive connection
+	// is closed.
+	IdleConnTimeout time.Duration
+}
+
+// Client calls diagnostics endpoints. A client reuses connections, so it
+// should be shared by all calls with the same configuration.
+type Client struct {
+	scheme      string
+	client      *http.Client
+	dialer      utils.ContextDialer
+	maxBodySize int64
+
+	// proxied is true if connections may go through a proxy, in which case
+	// their remote address is not the address of the called endpoint.
+	proxied bool
+}
+
+// NewClient creates a client from the configuration.
+func NewClient(cfg ClientConfig, options Options) (*Client, error) {
+	var dialer utils.ContextDialer = &net.Dialer{}
+	if cfg.Proxy != nil {
+		var err error
+		dialer, err = utils.NewProxyDialer(cfg.Proxy.URL, cfg.Proxy.NoProxy, dialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create proxy dialer: %w", err)
+		}
+	}
+
+	client, err := config_util.NewClientFromConfig(
+		cfg.HTTPClientConfig,
+		"keep_sd",
+		config_util.WithDialContextFunc(dialer.DialContext),
+		config_util.WithIdleConnTimeout(options.IdleConnTimeout),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http client: %w", err)
+	}
+	client.Timeout = options.Timeout
+
+	if len(cfg.Headers) > 0 {
+		client.Transport = &headersRoundTripper{
+			headers: cfg.Headers,
+			next:    client.Transport,
+		}
+	}
+
+	return &Client{
+		scheme:      cfg.Scheme,
+		client:      client,
+		dialer:      dialer,
+		maxBodySize: options.MaxBodySize,
+		proxied:     cfg.Proxy != nil || cfg.HTTPClientConfig.ProxyURL.URL != nil,
+	}, nil
+}
+
+// Dialer returns the dialer used by the client, so connections made outside
+// of the client, like port scans, take the same route.
+func (c *Client) Dialer() utils.ContextDialer {
+	return c.dialer
+}
+
+// Get calls the diagnostics endpoint under the address. Returned errors are
+// of the *Error type describing the failure category.
+func (c *Client) Get(ctx context.Context, addressWithPort string) (clientinfo.Diagnostics, error) {
+	diagnostics, _, err := c.GetWithRemoteIP(ctx, addressWithPort)
+	return diagnostics, err
+}
+
+// GetWithRemoteIP calls the diagnostics endpoint like Get and additionally
+// returns the IP address the diagnostics have been served from. The IP is
+// empty if the client connects through a proxy.
+func (c *Client) GetWithRemoteIP(
+	ctx context.Context,
+	addressWithPort string,
+) (clientinfo.Diagnostics, string, error) {
+	var remoteIP string
+
+	if !c.proxied {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if address, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+					remoteIP = address.IP.String()
+				}
+			},
+		})
+	}
+
+	diagnostics, err := c.get(ctx, addressWithPort)
+	if err != nil {
+		return diagnostics, "", err
+	}
+
+	return diagnostics, remoteIP, nil
+}
+
+func (c *Client) get(ctx context.Context, addressWithPort string) (clientinfo.Diagnostics, error) {
+	var diagnostics clientinfo.Diagnostics
+
+	if addressWithPort == "" {
+		return diagnostics, newError(CategoryRequest, "address is empty")
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s://%s/diagnostics", c.scheme, addressWithPort),
+		nil,
+	)
+	if err != nil {
+		return diagnostics, newError(CategoryRequest, "failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return diagnostics, newError(CategoryRequest, "failed to get diagnostics: %v", err)
+	}
+	defer func() {
+		// Drain the body to let the connection be reused, but don't read
+		// more than allowed from a misbehaving server.
+		io.Copy(io.Discard, io.LimitReader(resp.Body, c.maxBodySize))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return diagnostics, newError(CategoryStatus, "unexpected status code: %d", resp.StatusCode)
+	}
+
+	if err := checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return diagnostics, err
+	}
+
+	// Read one byte over the limit, so a body exceeding it is told apart
+	// from a body of exactly the limit's size.
+	body := &io.LimitedReader{R: resp.Body, N: c.maxBodySize + 1}
+	if err := decodeDiagnostics(body, &diagnostics); err != nil {
+		if body.N == 0 {
+			return diagnostics, newError(CategoryBodyTooLarge, "body exceeds %d bytes", c.maxBodySize)
+		}
+		return diagnostics, err
+	}
+	if body.N == 0 {
+		return diagnostics, newError(CategoryBodyTooLarge, "body exceeds %d bytes", c.maxBodySize)
+	}
+
+	if diagnostics.ClientInfo.ChainAddress == "" {
+		return diagnostics, newError(CategorySchema, "client_info.chain_address is missing")
+	}
+
+	return diagnostics, nil
+}
+
+// decodeDiagnostics decodes a single JSON document with the diagnostics from
+// the reader. Data following the document is rejected. Fields unknown to the
+// diagnostics are ignored, as nodes can serve sections of their applications
+// and newer clients can add fields.
+func decodeDiagnostics(r io.Reader, diagnostics *clientinfo.Diagnostics) error {
+	decoder := json.NewDecoder(r)
+
+	if err := decoder.Decode(diagnostics); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) {
+			return newError(CategoryType, "failed to decode diagnostics: %v", err)
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return newError(CategorySyntax, "failed to decode diagnostics: unexpected end of JSON input")
+		}
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			return newError(CategorySyntax, "failed to decode diagnostics: %v", err)
+		}
+		return newError(CategoryRequest, "failed to read body: %v", err)
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return newError(CategorySyntax, "failed to decode diagnostics: unexpected data after JSON document")
+	}
+
+	return nil
+}
+
+// Check calls the path under the address and returns an error if it doesn't
+// respond with a successful status code. The response body is discarded.
+func (c *Client) Check(ctx context.Context, addressWithPort, path string) error {
+	if addressWithPort == "" {
+		return newError(CategoryRequest, "address is empty")
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s://%s%s", c.scheme, addressWithPort, path),
+		nil,
+	)
+	if err != nil {
+		return newError(CategoryRequest, "failed to create request: %v", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return newError(CategoryRequest, "failed to call %s: %v", path, err)
+	}
+	defer func() {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, c.maxBodySize))
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newError(CategoryStatus, "unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// checkContentType verifies the response is not of a type that can't contain
+// diagnostics, like an HTML page served by a web server running on a scanned
+// port. Nodes don't set the content type explicitly, so the one detected by
+// the Go HTTP server for JSON, text/plain, is accepted as well.
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return newError(CategoryContentType, "invalid content type %q: %v", contentType, err)
+	}
+
+	switch mediaType {
+	case "application/json", "text/plain":
+		return nil
+	default:
+		return newError(CategoryContentType, "unexpected content type: %s", mediaType)
+	}
+}
+
+// headersRoundTripper sets custom headers on each request.
+type headersRoundTripper struct {
+	headers map[string]string
+	next    http.RoundTripper
+}
+
+func (rt *headersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range rt.headers {
+		req.Header.Set(name, value)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// Clients selects a client for diagnostics sources and peers according to
+// the configuration.
+type Clients struct {
+	defaultClient *Client
+	sources       map[string]*Client
+	peers         []peerClient
+}
+
+type peerClient struct {
+	config *PeerConfig
+	client *Client
+}
+
+// NewClients creates clients for all source groups and peer patterns in the
+// configuration. The configuration may be nil, in which case the default
+// client is used for all calls.
+func NewClients(cfg *Config, options Options) (*Clients, error) {
+	defaultConfig := DefaultClientConfig
+	if cfg != nil && cfg.Global != nil {
+		defaultConfig = cfg.Global.ClientConfig
+	}
+
+	defaultClient, err := NewClient(defaultConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := &Clients{
+		defaultClient: defaultClient,
+		sources:       make(map[string]*Client),
+		peers:         make([]peerClient, 0),
+	}
+
+	if cfg == nil {
+		return clients, nil
+	}
+
+	for i, source := range cfg.Sources {
+		client, err := NewClient(source.ClientConfig, options)
+		if err != nil {
+			return nil, fmt.Errorf("source group %d: %w", i, err)
+		}
+
+		for _, address := range source.Addresses {
+			if _, ok := clients.sources[address]; ok {
+				return nil, fmt.Errorf("source %s is configured in multiple groups", address)
+			}
+			clients.sources[address] = client
+		}
+	}
+
+	for i := range cfg.Peers {
+		client, err := NewClient(cfg.Peers[i].ClientConfig, options)
+		if err != nil {
+			return nil, fmt.Errorf("peer pattern %d: %w", i, err)
+		}
+
+		clients.peers = append(clients.peers, peerClient{
+			config: &cfg.Peers[i],
+			client: client,
+		})
+	}
+
+	return clients, nil
+}
+
+// ForSource returns the client for the diagnostics source address.
+func (c *Clients) ForSource(address string) *Client {
+	if client, ok := c.sources[address]; ok {
+		return client
+	}
+	return c.defaultClient
+}
+
+// ForPeer returns the client of the first peer pattern matching the peer's
+// chain address and network address.
+func (c *Clients) ForPeer(chainAddress, networkAddress string) *Client {
+	for _, peer := range c.peers {
+		if peer.config.matches(chainAddress, networkAddress) {
+			return peer.client
+		}
+	}
+	return c.defaultClient
+}
@@ -0,0 +1,310 @@
+package diagnostics
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+	config_util "github.com/prometheus/common/config"
+)
+
+var testOptions = Options{
+	Timeout:         time.Second,
+	MaxBodySize:     1024,
+	IdleConnTimeout: time.Minute,
+}
+
+func TestClientGet(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("X-Keep-Network") != "testnet" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(clientinfo.Diagnostics{
+			ClientInfo: clientinfo.Client{ChainAddress: "0xA"},
+		})
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultClientConfig
+	cfg.Scheme = "https"
+	cfg.Headers = map[string]string{"X-Keep-Network": "testnet"}
+	cfg.HTTPClientConfig.TLSConfig = config_util.TLSConfig{CAFile: caFile}
+	cfg.HTTPClientConfig.Authorization = &config_util.Authorization{
+		Type:        "Bearer",
+		Credentials: "secret",
+	}
+
+	client, err := NewClient(cfg, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := strings.TrimPrefix(server.URL, "https://")
+	diagnostics, err := client.Get(context.Background(), address)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diagnostics.ClientInfo.ChainAddress != "0xA" {
+		t.Errorf(
+			"invalid chain address\nexpected: %s\nactual:   %s",
+			"0xA",
+			diagnostics.ClientInfo.ChainAddress,
+		)
+	}
+
+	// The default client doesn't trust the server's certificate.
+	defaultClient, err := NewClient(DefaultClientConfig, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := defaultClient.Get(context.Background(), address); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestClientGetSafeguards(t *testing.T) {
+	var tests = map[string]struct {
+		status           int
+		contentType      string
+		body             string
+		expectedCategory ErrorCategory
+	}{
+		"valid diagnostics": {
+			status: http.StatusOK,
+			body:   `{"client_info":{"chain_address":"0xA"}}`,
+		},
+		"sniffed content type": {
+			status:      http.StatusOK,
+			contentType: "text/plain; charset=utf-8",
+			body:        `{"client_info":{"chain_address":"0xA"}}`,
+		},
+		"unexpected status": {
+			status:           http.StatusNotFound,
+			body:             `{"client_info":{"chain_address":"0xA"}}`,
+			expectedCategory: CategoryStatus,
+		},
+		"html page": {
+			status:           http.StatusOK,
+			contentType:      "text/html; charset=utf-8",
+			body:             "<html></html>",
+			expectedCategory: CategoryContentType,
+		},
+		"body too large": {
+			status:           http.StatusOK,
+			body:             `{"client_info":{"chain_address":"` + strings.Repeat("A", 1024) + `"}}`,
+			expectedCategory: CategoryBodyTooLarge,
+		},
+		"invalid json": {
+			status:           http.StatusOK,
+			body:             `{"client_info":`,
+			expectedCategory: CategorySyntax,
+		},
+		"trailing data": {
+			status:           http.StatusOK,
+			body:             `{"client_info":{"chain_address":"0xA"}} {}`,
+			expectedCategory: CategorySyntax,
+		},
+		"trailing garbage": {
+			status:           http.StatusOK,
+			body:             `{"client_info":{"chain_address":"0xA"}}]`,
+			expectedCategory: CategorySyntax,
+		},
+		"trailing whitespace": {
+			status: http.StatusOK,
+			body:   "{\"client_info\":{\"chain_address\":\"0xA\"}}\n\n",
+		},
+		"body at size limit": {
+			status: http.StatusOK,
+			body:   `{"client_info":{"chain_address":"0xA"}}` + strings.Repeat(" ", 1024-39),
+		},
+		"whitespace over size limit": {
+			status:           http.StatusOK,
+			body:             `{"client_info":{"chain_address":"0xA"}}` + strings.Repeat(" ", 1024),
+			expectedCategory: CategoryBodyTooLarge,
+		},
+		"empty body": {
+			status:           http.StatusOK,
+			body:             "",
+			expectedCategory: CategorySyntax,
+		},
+		"application section": {
+			status: http.StatusOK,
+			body:   `{"client_info":{"chain_address":"0xA"},"connected_peers":[],"application_info":{}}`,
+		},
+		"unknown nested field": {
+			status: http.StatusOK,
+			body:   `{"client_info":{"chain_address":"0xA","uptime":1}}`,
+		},
+		"invalid type": {
+			status:           http.StatusOK,
+			body:             `{"client_info":{"chain_address":1}}`,
+			expectedCategory: CategoryType,
+		},
+		"missing chain address": {
+			status:           http.StatusOK,
+			body:             `{"connected_peers":[]}`,
+			expectedCategory: CategorySchema,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(DefaultClientConfig, testOptions)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Get(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+
+			if actualCategory := CategoryOf(err); actualCategory != test.expectedCategory {
+				t.Errorf(
+					"invalid error category\nexpected: %s\nactual:   %s (%v)",
+					test.expectedCategory,
+					actualCategory,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestClientGetWithRemoteIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"client_info":{"chain_address":"0xA"}}`))
+	}))
+	defer server.Close()
+
+	address := strings.Replace(strings.TrimPrefix(server.URL, "http://"), "127.0.0.1", "localhost", 1)
+
+	client, err := NewClient(DefaultClientConfig, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, remoteIP, err := client.GetWithRemoteIP(context.Background(), address)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remoteIP != "127.0.0.1" {
+		t.Errorf("invalid remote ip\nexpected: %s\nactual:   %s", "127.0.0.1", remoteIP)
+	}
+
+	// The remote address of a proxied connection is the proxy's one.
+	cfg := DefaultClientConfig
+	cfg.Proxy = &ProxyConfig{URL: server.URL}
+	proxiedClient, err := NewClient(cfg, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proxiedClient.proxied {
+		t.Errorf("expected client to be proxied")
+	}
+}
+
+func TestClientCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metrics":
+			w.Write([]byte("connected_peers_count 3\n"))
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	client, err := NewClient(DefaultClientConfig, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Check(context.Background(), address, "/metrics"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"/unavailable", "/missing"} {
+		err := client.Check(context.Background(), address, path)
+		if category := CategoryOf(err); category != CategoryStatus {
+			t.Errorf("unexpected error of %s: %v", path, err)
+		}
+	}
+
+	if err := client.Check(context.Background(), "", "/metrics"); CategoryOf(err) != CategoryRequest {
+		t.Errorf("unexpected error of empty address: %v", err)
+	}
+}
+
+func TestClientGetIPv6(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 is not supported: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"client_info":{"chain_address":"0xA"}}`))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(DefaultClientConfig, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, remoteIP, err := client.GetWithRemoteIP(
+		context.Background(),
+		net.JoinHostPort("::1", port),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diagnostics.ClientInfo.ChainAddress != "0xA" {
+		t.Errorf(
+			"invalid chain address\nexpected: %s\nactual:   %s",
+			"0xA",
+			diagnostics.ClientInfo.ChainAddress,
+		)
+	}
+	if remoteIP != "::1" {
+		t.Errorf("invalid remote ip\nexpected: %s\nactual:   %s", "::1", remoteIP)
+	}
+}
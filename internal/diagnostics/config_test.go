@@ -0,0 +1,95 @@
+package diagnostics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+sources:
+  - addresses: [bootstrap-0.test.keep.network:443]
+    scheme: https
+    tls_config:
+      insecure_skip_verify: true
+    basic_auth:
+      username: keep
+      password: secret
+peers:
+  - chain_address: "0xA.*"
+    address: ".*\\.keep\\.network"
+    headers:
+      X-Keep-Network: testnet
+  - chain_address: "0xB.*"
+    scheme: https
+`
+
+func TestClientsSelection(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diagnostics.yml")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := NewClients(cfg, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = map[string]struct {
+		client         *Client
+		expectedClient *Client
+	}{
+		"configured source": {
+			client:         clients.ForSource("bootstrap-0.test.keep.network:443"),
+			expectedClient: clients.sources["bootstrap-0.test.keep.network:443"],
+		},
+		"unknown source": {
+			client:         clients.ForSource("bootstrap-1.test.keep.network:9601"),
+			expectedClient: clients.defaultClient,
+		},
+		"peer matching all patterns": {
+			client:         clients.ForPeer("0xA1", "node-1.keep.network"),
+			expectedClient: clients.peers[0].client,
+		},
+		"peer matching one of patterns": {
+			client:         clients.ForPeer("0xA1", "10.0.0.1"),
+			expectedClient: clients.defaultClient,
+		},
+		"peer matching second config": {
+			client:         clients.ForPeer("0xB1", "10.0.0.1"),
+			expectedClient: clients.peers[1].client,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if test.client != test.expectedClient {
+				t.Errorf("unexpected client selected")
+			}
+		})
+	}
+
+	if scheme := clients.ForSource("bootstrap-0.test.keep.network:443").scheme; scheme != "https" {
+		t.Errorf("invalid scheme\nexpected: %s\nactual:   %s", "https", scheme)
+	}
+	if scheme := clients.ForPeer("0xA1", "node-1.keep.network").scheme; scheme != "http" {
+		t.Errorf("invalid scheme\nexpected: %s\nactual:   %s", "http", scheme)
+	}
+}
+
+func TestLoadConfigInvalidScheme(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diagnostics.yml")
+	if err := os.WriteFile(path, []byte("peers:\n  - scheme: ftp\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("expected error")
+	}
+}
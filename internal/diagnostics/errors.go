@@ -0,0 +1,54 @@
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCategory describes why a diagnostics call failed.
+type ErrorCategory string
+
+const (
+	// CategoryRequest is a failure to send the request or read the response.
+	CategoryRequest ErrorCategory = "request"
+	// CategoryStatus is an unexpected response status code.
+	CategoryStatus ErrorCategory = "status"
+	// CategoryContentType is a response content type other than JSON.
+	CategoryContentType ErrorCategory = "content_type"
+	// CategoryBodyTooLarge is a response body exceeding the size limit.
+	CategoryBodyTooLarge ErrorCategory = "body_too_large"
+	// CategorySyntax is a response body that is not a valid JSON.
+	CategorySyntax ErrorCategory = "syntax"
+	// CategoryType is a JSON value of a type not matching the diagnostics.
+	CategoryType ErrorCategory = "type"
+	// CategorySchema is a JSON document missing required diagnostics fields.
+	CategorySchema ErrorCategory = "schema"
+)
+
+// Error is an error of a diagnostics call.
+type Error struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func newError(category ErrorCategory, format string, args ...interface{}) *Error {
+	return &Error{Category: category, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Category, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CategoryOf returns the category of the diagnostics call error, or an empty
+// category if the error is not a diagnostics call error.
+func CategoryOf(err error) ErrorCategory {
+	var diagnosticsErr *Error
+	if errors.As(err, &diagnosticsErr) {
+		return diagnosticsErr.Category
+	}
+	return ""
+}
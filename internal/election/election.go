@@ -0,0 +1,67 @@
+// Package election elects a leader among redundant discovery instances, so
+// only one of them scans the network.
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Elector acquires and keeps the leadership for an instance.
+type Elector interface {
+	// Campaign tries to acquire the leadership or renew it if the instance
+	// is already the leader. It returns true if the instance is the leader.
+	Campaign(ctx context.Context) (bool, error)
+	// Resign gives up the leadership if the instance is the leader.
+	Resign() error
+}
+
+// Watch campaigns for the leadership every interval until the context is
+// done, then resigns. The onChange function is called when the instance
+// becomes the leader or loses the leadership. A failed campaign is treated as
+// a lost leadership, so two instances never consider themselves leaders
+// because of an error.
+func Watch(
+	ctx context.Context,
+	elector Elector,
+	interval time.Duration,
+	logger log.Logger,
+	onChange func(leader bool),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	defer func() {
+		if err := elector.Resign(); err != nil {
+			level.Warn(logger).Log("msg", "failed to resign leadership", "err", err)
+		}
+	}()
+
+	leader := false
+	for {
+		isLeader, err := elector.Campaign(ctx)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to campaign for leadership", "err", err)
+			isLeader = false
+		}
+
+		if isLeader != leader {
+			leader = isLeader
+			if leader {
+				level.Info(logger).Log("msg", "acquired leadership")
+			} else {
+				level.Info(logger).Log("msg", "lost leadership")
+			}
+			onChange(leader)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
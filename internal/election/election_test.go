@@ -0,0 +1,212 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func TestLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	now := time.Now()
+
+	first := NewLease(path, "first", 10*time.Second)
+	first.now = func() time.Time { return now }
+	second := NewLease(path, "second", 10*time.Second)
+	second.now = func() time.Time { return now }
+
+	campaign := func(elector Elector, expectedLeader bool) {
+		t.Helper()
+		leader, err := elector.Campaign(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leader != expectedLeader {
+			t.Errorf("invalid leadership\nexpected: %v\nactual:   %v", expectedLeader, leader)
+		}
+	}
+
+	campaign(first, true)
+	campaign(second, false)
+
+	// The leader renews the lease, so it doesn't expire.
+	now = now.Add(8 * time.Second)
+	campaign(first, true)
+	now = now.Add(8 * time.Second)
+	campaign(second, false)
+
+	// The lease expires when the leader stops renewing it.
+	now = now.Add(3 * time.Second)
+	campaign(second, true)
+	campaign(first, false)
+
+	// The leader resigns, so the other instance takes over immediately.
+	if err := second.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	campaign(first, true)
+}
+
+func TestLeaseConcurrentTakeover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	now := time.Now()
+
+	leases := make([]*Lease, 8)
+	for i := range leases {
+		leases[i] = NewLease(path, fmt.Sprintf("instance-%d", i), 10*time.Second)
+		leases[i].now = func() time.Time { return now }
+	}
+
+	for round := 0; round < 50; round++ {
+		// Each round starts with the previous leader's lease expired, so all
+		// instances try to take it over at once.
+		now = now.Add(time.Minute)
+
+		start := make(chan struct{})
+		results := make(chan bool, len(leases))
+		var wg sync.WaitGroup
+		for _, lease := range leases {
+			wg.Add(1)
+			go func(lease *Lease) {
+				defer wg.Done()
+				<-start
+
+				leader, err := lease.Campaign(context.Background())
+				if err != nil {
+					t.Error(err)
+				}
+				results <- leader
+			}(lease)
+		}
+		close(start)
+		wg.Wait()
+		close(results)
+
+		leaders := 0
+		for leader := range results {
+			if leader {
+				leaders++
+			}
+		}
+		if leaders != 1 {
+			t.Fatalf("round %d elected %d leaders", round, leaders)
+		}
+	}
+}
+
+func TestLeaseStaleWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+	now := time.Now()
+
+	first := NewLease(path, "first", 10*time.Second)
+	first.now = func() time.Time { return now }
+	second := NewLease(path, "second", 10*time.Second)
+	second.now = func() time.Time { return now }
+
+	if leader, err := first.Campaign(context.Background()); err != nil || !leader {
+		t.Fatalf("expected first to be the leader: %v, %v", leader, err)
+	}
+	stale, err := second.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leader renews the lease a few times, so the generation the second
+	// instance has read is removed.
+	for i := 0; i < 3; i++ {
+		if leader, err := first.Campaign(context.Background()); err != nil || !leader {
+			t.Fatalf("expected first to stay the leader: %v, %v", leader, err)
+		}
+	}
+
+	// The second instance writing the generation following the one it has read
+	// doesn't take the lease over, although the generation's file is gone.
+	written, err := second.write(leaseRecord{
+		Generation: stale.Generation + 1,
+		Holder:     "second",
+		ExpiresAt:  now.Add(10 * time.Second),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written {
+		t.Errorf("stale generation written")
+	}
+	if leader, err := first.Campaign(context.Background()); err != nil || !leader {
+		t.Errorf("expected first to stay the leader: %v, %v", leader, err)
+	}
+
+	// Only the latest generation is kept.
+	generations, err := first.generations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generations) != 1 {
+		t.Errorf("unexpected generations: %v", generations)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader.lock")
+
+	first, err := NewLockFile(path)
+	if err != nil {
+		t.Skip(err)
+	}
+	second, err := NewLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if leader, err := first.Campaign(context.Background()); err != nil || !leader {
+		t.Fatalf("expected first to be the leader: %v, %v", leader, err)
+	}
+	if leader, err := second.Campaign(context.Background()); err != nil || leader {
+		t.Fatalf("expected second not to be the leader: %v, %v", leader, err)
+	}
+
+	if err := first.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if leader, err := second.Campaign(context.Background()); err != nil || !leader {
+		t.Fatalf("expected second to be the leader: %v, %v", leader, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lease.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes := make(chan bool, 10)
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, NewLease(path, "first", time.Minute), 10*time.Millisecond, log.NewNopLogger(), func(leader bool) {
+			changes <- leader
+		})
+		close(done)
+	}()
+
+	select {
+	case leader := <-changes:
+		if !leader {
+			t.Errorf("expected leadership to be acquired")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership not acquired")
+	}
+
+	cancel()
+	<-done
+
+	// The leadership is given up on exit.
+	leader, err := NewLease(path, "second", time.Minute).Campaign(context.Background())
+	if err != nil || !leader {
+		t.Errorf("expected second to be the leader: %v, %v", leader, err)
+	}
+}
@@ -0,0 +1,222 @@
+package election
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readAttempts limits retries of reading the lease removed by another
+// instance after it wrote a newer generation.
+const readAttempts = 3
+
+// Lease is an elector holding the leadership with a lease on a filesystem
+// shared by the instances. The leader renews the lease before it expires;
+// another instance takes over once the lease expires. Clocks of the
+// instances' hosts are expected to be synchronized well within the lease
+// duration.
+//
+// Each acquisition, renewal and release writes a new generation of the lease
+// to a file named after the path with the generation as a suffix. The file is
+// hard-linked from a fully written temporary file, which fails if the file
+// exists, so of instances writing the same generation exactly one succeeds.
+type Lease struct {
+	path     string
+	id       string
+	duration time.Duration
+
+	now func() time.Time
+}
+
+type leaseRecord struct {
+	Generation uint64    `json:"generation"`
+	Holder     string    `json:"holder"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// NewLease creates an elector for the instance identified by the ID using
+// the lease files at the path.
+func NewLease(path, id string, duration time.Duration) *Lease {
+	return &Lease{path: path, id: id, duration: duration, now: time.Now}
+}
+
+// Campaign acquires the lease if it is not held or expired, or renews it if
+// it is held by the instance.
+func (l *Lease) Campaign(ctx context.Context) (bool, error) {
+	now := l.now()
+
+	current, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	if current.Holder != "" && current.Holder != l.id && now.Before(current.ExpiresAt) {
+		return false, nil
+	}
+
+	// Instances taking over an expired lease at the same time write the same
+	// generation; only the first one succeeds. So does the leader renewing
+	// the lease if another instance took it over in the meantime.
+	return l.write(leaseRecord{
+		Generation: current.Generation + 1,
+		Holder:     l.id,
+		ExpiresAt:  now.Add(l.duration),
+	})
+}
+
+// Resign releases the lease if it is held by the instance.
+func (l *Lease) Resign() error {
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	if current.Holder != l.id {
+		return nil
+	}
+
+	// The lease is released with a new generation rather than removed, so an
+	// instance that took it over in the meantime keeps it.
+	_, err = l.write(leaseRecord{Generation: current.Generation + 1})
+	return err
+}
+
+// read returns the latest generation of the lease, or an empty record if the
+// lease has never been written.
+func (l *Lease) read() (leaseRecord, error) {
+	for attempt := 1; ; attempt++ {
+		generations, err := l.generations()
+		if err != nil {
+			return leaseRecord{}, err
+		}
+		if len(generations) == 0 {
+			return leaseRecord{}, nil
+		}
+		latest := generations[len(generations)-1]
+
+		content, err := os.ReadFile(l.generationPath(latest))
+		if errors.Is(err, os.ErrNotExist) && attempt < readAttempts {
+			// The generation has been removed after a newer one was written.
+			continue
+		}
+		if err != nil {
+			return leaseRecord{}, fmt.Errorf("failed to read lease: %w", err)
+		}
+
+		record := leaseRecord{}
+		if err := json.Unmarshal(content, &record); err != nil {
+			return leaseRecord{}, fmt.Errorf("failed to decode lease: %w", err)
+		}
+		if record.Generation != latest {
+			return leaseRecord{}, fmt.Errorf(
+				"lease of generation %d found in file of generation %d",
+				record.Generation,
+				latest,
+			)
+		}
+		return record, nil
+	}
+}
+
+// write writes the generation of the lease. It returns false if the
+// generation has already been written by another instance.
+func (l *Lease) write(record leaseRecord) (bool, error) {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode lease: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "."+filepath.Base(l.path)+".*.tmp")
+	if err != nil {
+		return false, fmt.Errorf("failed to create lease: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("failed to write lease: %w", err)
+	}
+
+	err = os.Link(tmp.Name(), l.generationPath(record.Generation))
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to link lease: %w", err)
+	}
+
+	// An instance that read the lease before newer generations were written
+	// can write a generation already removed as outdated, so the write counts
+	// only if it's still the latest one.
+	generations, err := l.generations()
+	if err != nil {
+		return false, err
+	}
+	if len(generations) == 0 || generations[len(generations)-1] != record.Generation {
+		return false, nil
+	}
+
+	l.removeGenerationsBefore(record.Generation)
+
+	return true, nil
+}
+
+// generations returns the generations of the lease present on the
+// filesystem in ascending order.
+func (l *Lease) generations() ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Dir(l.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list leases: %w", err)
+	}
+
+	prefix := filepath.Base(l.path) + "."
+	generations := make([]uint64, 0, 1)
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		generation, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		generations = append(generations, generation)
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i] < generations[j]
+	})
+	return generations, nil
+}
+
+// removeGenerationsBefore removes generations older than the given one. The
+// latest generation is always kept, so the lease can't be lost; failures are
+// ignored, as older generations don't affect the election.
+func (l *Lease) removeGenerationsBefore(generation uint64) {
+	generations, err := l.generations()
+	if err != nil {
+		return
+	}
+
+	for _, older := range generations {
+		if older >= generation {
+			break
+		}
+		os.Remove(l.generationPath(older))
+	}
+}
+
+func (l *Lease) generationPath(generation uint64) string {
+	return fmt.Sprintf("%s.%d", l.path, generation)
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// LockFile is an elector holding the leadership with an exclusive lock on a
+// local file. The lock is released by the operating system when the leader's
+// process exits, so a follower takes over on its next campaign.
+type LockFile struct {
+	path string
+
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewLockFile creates an elector using the lock file at the path.
+func NewLockFile(path string) (*LockFile, error) {
+	return &LockFile{path: path}, nil
+}
+
+// Campaign tries to lock the file without blocking.
+func (l *LockFile) Campaign(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		file.Close()
+		return false, nil
+	}
+	if err != nil {
+		file.Close()
+		return false, fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	l.file = file
+	return true, nil
+}
+
+// Resign unlocks the file if it is locked by the instance.
+func (l *LockFile) Resign() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	l.file = nil
+
+	if err != nil {
+		return fmt.Errorf("failed to unlock file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package election
+
+import (
+	"context"
+	"fmt"
+)
+
+// LockFile is not supported on Windows; use Lease instead.
+type LockFile struct{}
+
+// NewLockFile returns an error as file locks are not supported on Windows.
+func NewLockFile(path string) (*LockFile, error) {
+	return nil, fmt.Errorf("lock file election is not supported on windows")
+}
+
+// Campaign is never called as NewLockFile fails.
+func (l *LockFile) Campaign(ctx context.Context) (bool, error) {
+	return false, fmt.Errorf("lock file election is not supported on windows")
+}
+
+// Resign is never called as NewLockFile fails.
+func (l *LockFile) Resign() error {
+	return nil
+}
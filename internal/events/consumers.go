@@ -0,0 +1,241 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// LogConsumer writes each event as a structured log entry.
+type LogConsumer struct {
+	logger log.Logger
+}
+
+// NewLogConsumer creates a consumer logging events with the logger.
+func NewLogConsumer(logger log.Logger) *LogConsumer {
+	return &LogConsumer{logger: logger}
+}
+
+// Consume is an implementation of the Consumer interface.
+func (c *LogConsumer) Consume(events []Event) {
+	for _, event := range events {
+		keyvals := []interface{}{
+			"msg", "peer lifecycle event",
+			"type", event.Type,
+			"source", event.Source,
+		}
+		if event.Endpoint != "" {
+			keyvals = append(keyvals, "endpoint", event.Endpoint)
+		}
+		if event.PreviousEndpoint != "" {
+			keyvals = append(keyvals, "previousEndpoint", event.PreviousEndpoint)
+		}
+		if event.Type == LabelsChanged {
+			keyvals = append(
+				keyvals,
+				"labels", fmt.Sprintf("%v", event.Labels),
+				"previousLabels", fmt.Sprintf("%v", event.PreviousLabels),
+			)
+		}
+
+		level.Info(c.logger).Log(keyvals...)
+	}
+}
+
+// WebhookConsumer posts events as a JSON array to a webhook URL. Deliveries
+// are queued and sent in the background, failed deliveries are retried with
+// an exponential backoff.
+type WebhookConsumer struct {
+	url        string
+	client     *http.Client
+	retries    int
+	retryDelay time.Duration
+	logger     log.Logger
+
+	queue chan []Event
+}
+
+// NewWebhookConsumer creates a webhook consumer and starts its delivery loop
+// which runs until the context is done.
+func NewWebhookConsumer(
+	ctx context.Context,
+	url string,
+	timeout time.Duration,
+	retries int,
+	retryDelay time.Duration,
+	logger log.Logger,
+) *WebhookConsumer {
+	c := &WebhookConsumer{
+		url:        url,
+		client:     &http.Client{Timeout: timeout},
+		retries:    retries,
+		retryDelay: retryDelay,
+		logger:     log.With(logger, "webhook", url),
+		queue:      make(chan []Event, 100),
+	}
+
+	go c.deliveryLoop(ctx)
+
+	return c
+}
+
+// Consume is an implementation of the Consumer interface. Events are dropped
+// if the delivery queue is full.
+func (c *WebhookConsumer) Consume(events []Event) {
+	select {
+	case c.queue <- events:
+	default:
+		level.Warn(c.logger).Log(
+			"msg", "webhook delivery queue is full; dropping events",
+			"events", len(events),
+		)
+	}
+}
+
+func (c *WebhookConsumer) deliveryLoop(ctx context.Context) {
+	for {
+		select {
+		case events := <-c.queue:
+			if err := c.deliver(ctx, events); err != nil {
+				level.Error(c.logger).Log(
+					"msg", "failed to deliver events to webhook",
+					"events", len(events),
+					"err", err,
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *WebhookConsumer) deliver(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %w", err)
+	}
+
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err = c.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= c.retries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+
+		level.Warn(c.logger).Log(
+			"msg", "failed to deliver events to webhook; retrying",
+			"attempt", attempt+1,
+			"retryIn", delay,
+			"err", err,
+		)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (c *WebhookConsumer) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SSEConsumer streams events to HTTP clients as server-sent events. Each
+// event is sent with its type as the event name and JSON encoded data.
+type SSEConsumer struct {
+	mutex   sync.Mutex
+	clients map[chan Event]struct{}
+}
+
+// NewSSEConsumer creates a server-sent events consumer.
+func NewSSEConsumer() *SSEConsumer {
+	return &SSEConsumer{
+		clients: make(map[chan Event]struct{}),
+	}
+}
+
+// Consume is an implementation of the Consumer interface. Events are dropped
+// for clients that don't keep up with the stream.
+func (c *SSEConsumer) Consume(events []Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for client := range c.clients {
+		for _, event := range events {
+			select {
+			case client <- event:
+			default:
+			}
+		}
+	}
+}
+
+// ServeHTTP streams events to the client until the request is done.
+func (c *SSEConsumer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan Event, 100)
+
+	c.mutex.Lock()
+	c.clients[client] = struct{}{}
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.clients, client)
+		c.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event := <-client:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func TestWebhookConsumerRetries(t *testing.T) {
+	var attempts int32
+	delivered := make(chan []Event, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first two attempts.
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var events []Event
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			t.Errorf("failed to decode events: %v", err)
+		}
+		delivered <- events
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumer := NewWebhookConsumer(ctx, server.URL, time.Second, 3, time.Millisecond, log.NewNopLogger())
+	consumer.Consume([]Event{{Type: Added, Source: "0xA"}})
+
+	select {
+	case events := <-delivered:
+		if len(events) != 1 || events[0].Source != "0xA" {
+			t.Errorf("invalid delivered events: %+v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("events not delivered")
+	}
+
+	if actualAttempts := atomic.LoadInt32(&attempts); actualAttempts != 3 {
+		t.Errorf("invalid number of attempts\nexpected: %d\nactual:   %d", 3, actualAttempts)
+	}
+}
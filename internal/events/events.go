@@ -0,0 +1,158 @@
+// Package events computes peer lifecycle changes between discovery rounds and
+// publishes them to consumers.
+package events
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// Type is a type of peer lifecycle change.
+type Type string
+
+const (
+	// Added is emitted when a target group appears for the first time.
+	Added Type = "added"
+	// Removed is emitted when a target group disappears.
+	Removed Type = "removed"
+	// EndpointChanged is emitted when a target group's address changes.
+	EndpointChanged Type = "endpoint_changed"
+	// LabelsChanged is emitted when a target group's labels other than the
+	// address change.
+	LabelsChanged Type = "labels_changed"
+)
+
+// Event is a change of a target group between two discovery rounds.
+type Event struct {
+	Type   Type      `json:"type"`
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+
+	Endpoint         string `json:"endpoint,omitempty"`
+	PreviousEndpoint string `json:"previous_endpoint,omitempty"`
+
+	Labels         map[string]string `json:"labels,omitempty"`
+	PreviousLabels map[string]string `json:"previous_labels,omitempty"`
+}
+
+// Consumer receives events computed in a discovery round. Implementations
+// must not block the discovery.
+type Consumer interface {
+	Consume(events []Event)
+}
+
+// Publisher distributes events to a set of consumers.
+type Publisher struct {
+	consumers []Consumer
+}
+
+// NewPublisher creates a publisher for the consumers.
+func NewPublisher(consumers ...Consumer) *Publisher {
+	return &Publisher{consumers: consumers}
+}
+
+// Publish passes the events to all consumers. Nothing is published if there
+// are no events.
+func (p *Publisher) Publish(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	for _, consumer := range p.consumers {
+		consumer.Consume(events)
+	}
+}
+
+// Diff computes changes between target groups of two discovery rounds. Groups
+// are identified by their source. The events are sorted by source.
+func Diff(previous, current map[string]*targetgroup.Group, at time.Time) []Event {
+	events := make([]Event, 0)
+
+	for source, group := range current {
+		previousGroup, ok := previous[source]
+		if !ok {
+			events = append(events, Event{
+				Type:     Added,
+				Time:     at,
+				Source:   source,
+				Endpoint: endpoint(group),
+				Labels:   labels(group),
+			})
+			continue
+		}
+
+		if endpoint(group) != endpoint(previousGroup) {
+			events = append(events, Event{
+				Type:             EndpointChanged,
+				Time:             at,
+				Source:           source,
+				Endpoint:         endpoint(group),
+				PreviousEndpoint: endpoint(previousGroup),
+			})
+		}
+
+		if !equalLabels(labels(group), labels(previousGroup)) {
+			events = append(events, Event{
+				Type:           LabelsChanged,
+				Time:           at,
+				Source:         source,
+				Endpoint:       endpoint(group),
+				Labels:         labels(group),
+				PreviousLabels: labels(previousGroup),
+			})
+		}
+	}
+
+	for source, previousGroup := range previous {
+		if _, ok := current[source]; !ok {
+			events = append(events, Event{
+				Type:             Removed,
+				Time:             at,
+				Source:           source,
+				PreviousEndpoint: endpoint(previousGroup),
+				PreviousLabels:   labels(previousGroup),
+			})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Source < events[j].Source
+	})
+
+	return events
+}
+
+// endpoint returns the address of the first target in the group.
+func endpoint(group *targetgroup.Group) string {
+	if len(group.Targets) == 0 {
+		return ""
+	}
+	return string(group.Targets[0][model.AddressLabel])
+}
+
+// labels returns the group's labels without the address label.
+func labels(group *targetgroup.Group) map[string]string {
+	result := make(map[string]string, len(group.Labels))
+	for name, value := range group.Labels {
+		if name == model.AddressLabel {
+			continue
+		}
+		result[string(name)] = string(value)
+	}
+	return result
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func group(source, address, networkID string) *targetgroup.Group {
+	return &targetgroup.Group{
+		Source:  source,
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(address)}},
+		Labels: model.LabelSet{
+			model.AddressLabel:     model.LabelValue(address),
+			"__meta_chain_address": model.LabelValue(source),
+			"__meta_network_id":    model.LabelValue(networkID),
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	at := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	previous := map[string]*targetgroup.Group{
+		"0xA": group("0xA", "10.0.0.1:9601", "16Uiu2A"),
+		"0xB": group("0xB", "10.0.0.2:9601", "16Uiu2B"),
+		"0xC": group("0xC", "10.0.0.3:9601", "16Uiu2C"),
+		"0xD": group("0xD", "10.0.0.4:9601", "16Uiu2D"),
+	}
+	current := map[string]*targetgroup.Group{
+		"0xA": group("0xA", "10.0.0.1:9601", "16Uiu2A"),
+		"0xB": group("0xB", "10.0.0.2:9602", "16Uiu2B"),
+		"0xC": group("0xC", "10.0.0.3:9601", "16Uiu2X"),
+		"0xE": group("0xE", "10.0.0.5:9601", "16Uiu2E"),
+	}
+
+	expectedEvents := []Event{
+		{
+			Type:             EndpointChanged,
+			Time:             at,
+			Source:           "0xB",
+			Endpoint:         "10.0.0.2:9602",
+			PreviousEndpoint: "10.0.0.2:9601",
+		},
+		{
+			Type:           LabelsChanged,
+			Time:           at,
+			Source:         "0xC",
+			Endpoint:       "10.0.0.3:9601",
+			Labels:         map[string]string{"__meta_chain_address": "0xC", "__meta_network_id": "16Uiu2X"},
+			PreviousLabels: map[string]string{"__meta_chain_address": "0xC", "__meta_network_id": "16Uiu2C"},
+		},
+		{
+			Type:             Removed,
+			Time:             at,
+			Source:           "0xD",
+			PreviousEndpoint: "10.0.0.4:9601",
+			PreviousLabels:   map[string]string{"__meta_chain_address": "0xD", "__meta_network_id": "16Uiu2D"},
+		},
+		{
+			Type:     Added,
+			Time:     at,
+			Source:   "0xE",
+			Endpoint: "10.0.0.5:9601",
+			Labels:   map[string]string{"__meta_chain_address": "0xE", "__meta_network_id": "16Uiu2E"},
+		},
+	}
+
+	actualEvents := Diff(previous, current, at)
+
+	if !reflect.DeepEqual(expectedEvents, actualEvents) {
+		t.Errorf("invalid events\nexpected: %+v\nactual:   %+v", expectedEvents, actualEvents)
+	}
+}
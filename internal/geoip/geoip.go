@@ -0,0 +1,104 @@
+// Package geoip looks up locations and autonomous systems of IP addresses in
+// offline MaxMind databases.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	labelCountry = model.MetaLabelPrefix + "keep_geo_country"
+	labelCity    = model.MetaLabelPrefix + "keep_geo_city"
+	labelASN     = model.MetaLabelPrefix + "keep_asn"
+	labelASNOrg  = model.MetaLabelPrefix + "keep_asn_org"
+)
+
+// Databases holds the opened City and ASN databases. Any of them may be
+// missing, in which case labels it provides are not set.
+type Databases struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+}
+
+// Open opens MMDB files of GeoLite2 City or GeoIP2 City and GeoLite2 ASN
+// databases. An empty path skips the database.
+func Open(cityPath, asnPath string) (*Databases, error) {
+	databases := &Databases{}
+
+	if cityPath != "" {
+		city, err := geoip2.Open(cityPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open city database: %w", err)
+		}
+		databases.city = city
+	}
+
+	if asnPath != "" {
+		asn, err := geoip2.Open(asnPath)
+		if err != nil {
+			databases.Close()
+			return nil, fmt.Errorf("failed to open asn database: %w", err)
+		}
+		databases.asn = asn
+	}
+
+	return databases, nil
+}
+
+// Close closes the databases.
+func (d *Databases) Close() error {
+	var err error
+	if d.city != nil {
+		err = d.city.Close()
+	}
+	if d.asn != nil {
+		if asnErr := d.asn.Close(); asnErr != nil {
+			err = asnErr
+		}
+	}
+	return err
+}
+
+// Labels returns __meta_keep_geo_* and __meta_keep_asn* labels of the IP
+// address. Labels of data missing in the databases are not set.
+func (d *Databases) Labels(ip net.IP) (model.LabelSet, error) {
+	labels := model.LabelSet{}
+
+	if d.city != nil {
+		city, err := d.city.City(ip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up city: %w", err)
+		}
+		if city.Country.IsoCode != "" {
+			labels[labelCountry] = model.LabelValue(city.Country.IsoCode)
+		}
+		if name := city.City.Names["en"]; name != "" {
+			labels[labelCity] = model.LabelValue(name)
+		}
+	}
+
+	if d.asn != nil {
+		asn, err := d.asn.ASN(ip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up asn: %w", err)
+		}
+		if asn.AutonomousSystemNumber != 0 {
+			labels[labelASN] = model.LabelValue(strconv.FormatUint(uint64(asn.AutonomousSystemNumber), 10))
+		}
+		if asn.AutonomousSystemOrganization != "" {
+			labels[labelASNOrg] = model.LabelValue(asn.AutonomousSystemOrganization)
+		}
+	}
+
+	return labels, nil
+}
+
+// LabelNames returns names of all labels IP addresses may be labeled with.
+func LabelNames() []model.LabelName {
+	return []model.LabelName{labelCountry, labelCity, labelASN, labelASNOrg}
+}
@@ -0,0 +1,105 @@
+package geoip
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+// The test databases are generated with github.com/maxmind/mmdbwriter and
+// contain records for 81.2.69.0/24 and 2001:db8:1::/48 only.
+const (
+	testCityDatabase = "testdata/GeoLite2-City-Test.mmdb"
+	testASNDatabase  = "testdata/GeoLite2-ASN-Test.mmdb"
+)
+
+func TestLabels(t *testing.T) {
+	databases, err := Open(testCityDatabase, testASNDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databases.Close()
+
+	var tests = map[string]struct {
+		ip             string
+		expectedLabels model.LabelSet
+	}{
+		"ipv4": {
+			ip: "81.2.69.160",
+			expectedLabels: model.LabelSet{
+				"__meta_keep_geo_country": "GB",
+				"__meta_keep_geo_city":    "London",
+				"__meta_keep_asn":         "64496",
+				"__meta_keep_asn_org":     "Example Hosting Ltd",
+			},
+		},
+		"ipv6": {
+			ip: "2001:db8:1::1",
+			expectedLabels: model.LabelSet{
+				"__meta_keep_geo_country": "DE",
+				"__meta_keep_geo_city":    "Frankfurt am Main",
+				"__meta_keep_asn":         "64497",
+				"__meta_keep_asn_org":     "Example Cloud GmbH",
+			},
+		},
+		"unknown": {
+			ip:             "34.141.9.57",
+			expectedLabels: model.LabelSet{},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			labels, err := databases.Labels(net.ParseIP(test.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(labels, test.expectedLabels) {
+				t.Errorf(
+					"invalid labels\nexpected: %v\nactual:   %v",
+					test.expectedLabels,
+					labels,
+				)
+			}
+		})
+	}
+}
+
+func TestLabelsSingleDatabase(t *testing.T) {
+	databases, err := Open("", testASNDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databases.Close()
+
+	labels, err := databases.Labels(net.ParseIP("81.2.69.160"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedLabels := model.LabelSet{
+		"__meta_keep_asn":     "64496",
+		"__meta_keep_asn_org": "Example Hosting Ltd",
+	}
+	if !reflect.DeepEqual(labels, expectedLabels) {
+		t.Errorf("invalid labels\nexpected: %v\nactual:   %v", expectedLabels, labels)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	if _, err := Open("testdata/missing.mmdb", ""); err == nil {
+		t.Errorf("expected error")
+	}
+	// The ASN database can't be used as a city database and vice versa.
+	databases, err := Open(testASNDatabase, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databases.Close()
+	if _, err := databases.Labels(net.ParseIP("81.2.69.160")); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -0,0 +1,269 @@
+// Package graph builds the connectivity graph of the network and exports it
+// in GraphViz DOT, GraphML and JSON formats.
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Graph is an undirected graph of peers identified by chain addresses.
+type Graph struct {
+	adjacency map[string]map[string]struct{}
+}
+
+// New creates an empty graph.
+func New() *Graph {
+	return &Graph{adjacency: make(map[string]map[string]struct{})}
+}
+
+// AddNode adds the node to the graph.
+func (g *Graph) AddNode(id string) {
+	if _, ok := g.adjacency[id]; !ok {
+		g.adjacency[id] = make(map[string]struct{})
+	}
+}
+
+// AddEdge connects the nodes, adding them to the graph if needed. Self-loops
+// are ignored.
+func (g *Graph) AddEdge(a, b string) {
+	g.AddNode(a)
+	g.AddNode(b)
+
+	if a == b {
+		return
+	}
+
+	g.adjacency[a][b] = struct{}{}
+	g.adjacency[b][a] = struct{}{}
+}
+
+// Nodes returns sorted nodes of the graph.
+func (g *Graph) Nodes() []string {
+	nodes := make([]string, 0, len(g.adjacency))
+	for node := range g.adjacency {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Neighbors returns sorted nodes connected with the node.
+func (g *Graph) Neighbors(id string) []string {
+	neighbors := make([]string, 0, len(g.adjacency[id]))
+	for neighbor := range g.adjacency[id] {
+		neighbors = append(neighbors, neighbor)
+	}
+	sort.Strings(neighbors)
+	return neighbors
+}
+
+// Edge connects two nodes; A sorts before B.
+type Edge struct {
+	A, B string
+}
+
+// Edges returns sorted edges of the graph.
+func (g *Graph) Edges() []Edge {
+	edges := make([]Edge, 0)
+	for _, node := range g.Nodes() {
+		for _, neighbor := range g.Neighbors(node) {
+			if node < neighbor {
+				edges = append(edges, Edge{A: node, B: neighbor})
+			}
+		}
+	}
+	return edges
+}
+
+// Components returns connected components of the graph, the largest first.
+// Nodes of each component are sorted.
+func (g *Graph) Components() [][]string {
+	visited := make(map[string]bool, len(g.adjacency))
+	components := make([][]string, 0)
+
+	for _, node := range g.Nodes() {
+		if visited[node] {
+			continue
+		}
+
+		component := make([]string, 0)
+		queue := []string{node}
+		visited[node] = true
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			component = append(component, current)
+
+			for neighbor := range g.adjacency[current] {
+				if !visited[neighbor] {
+					visited[neighbor] = true
+					queue = append(queue, neighbor)
+				}
+			}
+		}
+
+		sort.Strings(component)
+		components = append(components, component)
+	}
+
+	sort.SliceStable(components, func(i, j int) bool {
+		return len(components[i]) > len(components[j])
+	})
+
+	return components
+}
+
+// Stats summarizes the graph.
+type Stats struct {
+	Nodes int `json:"nodes"`
+	Edges int `json:"edges"`
+	// DegreeDistribution maps a degree to the number of nodes with it.
+	DegreeDistribution map[int]int `json:"degree_distribution"`
+	AverageDegree      float64     `json:"average_degree"`
+	MaxDegree          int         `json:"max_degree"`
+	// IsolatedNodes are nodes without any connections.
+	IsolatedNodes []string `json:"isolated_nodes"`
+	// Components is the number of connected components; more than one
+	// means the network is partitioned.
+	Components int `json:"components"`
+}
+
+// Stats computes the summary of the graph.
+func (g *Graph) Stats() Stats {
+	stats := Stats{
+		Nodes:              len(g.adjacency),
+		DegreeDistribution: make(map[int]int),
+		IsolatedNodes:      make([]string, 0),
+	}
+
+	totalDegree := 0
+	for _, node := range g.Nodes() {
+		degree := len(g.adjacency[node])
+
+		stats.DegreeDistribution[degree]++
+		totalDegree += degree
+		if degree > stats.MaxDegree {
+			stats.MaxDegree = degree
+		}
+		if degree == 0 {
+			stats.IsolatedNodes = append(stats.IsolatedNodes, node)
+		}
+	}
+
+	stats.Edges = totalDegree / 2
+	stats.Components = len(g.Components())
+	if stats.Nodes > 0 {
+		stats.AverageDegree = float64(totalDegree) / float64(stats.Nodes)
+	}
+
+	return stats
+}
+
+// WriteDOT writes the graph in the GraphViz DOT format.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var builder strings.Builder
+
+	builder.WriteString("graph keep {\n")
+	for _, node := range g.Nodes() {
+		fmt.Fprintf(&builder, "  %s;\n", quoteDOT(node))
+	}
+	for _, edge := range g.Edges() {
+		fmt.Fprintf(&builder, "  %s -- %s;\n", quoteDOT(edge.A), quoteDOT(edge.B))
+	}
+	builder.WriteString("}\n")
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func quoteDOT(id string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(id, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// WriteGraphML writes the graph in the GraphML format.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	document := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{
+			ID:          "keep",
+			EdgeDefault: "undirected",
+			Nodes:       make([]graphMLNode, 0, len(g.adjacency)),
+			Edges:       make([]graphMLEdge, 0),
+		},
+	}
+	for _, node := range g.Nodes() {
+		document.Graph.Nodes = append(document.Graph.Nodes, graphMLNode{ID: node})
+	}
+	for _, edge := range g.Edges() {
+		document.Graph.Edges = append(document.Graph.Edges, graphMLEdge{Source: edge.A, Target: edge.B})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON writes the graph as a JSON adjacency list mapping each node to its
+// neighbors.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	adjacency := make(map[string][]string, len(g.adjacency))
+	for node := range g.adjacency {
+		adjacency[node] = g.Neighbors(node)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Nodes map[string][]string `json:"nodes"`
+	}{adjacency})
+}
+
+// Write writes the graph in the format: dot, graphml or json.
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "graphml":
+		return g.WriteGraphML(w)
+	case "json":
+		return g.WriteJSON(w)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
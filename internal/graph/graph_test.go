@@ -0,0 +1,125 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func testGraph() *Graph {
+	g := New()
+	g.AddEdge("0xA", "0xB")
+	g.AddEdge("0xA", "0xC")
+	g.AddEdge("0xC", "0xA")
+	g.AddEdge("0xB", "0xB")
+	g.AddNode("0xD")
+	return g
+}
+
+func TestStats(t *testing.T) {
+	stats := testGraph().Stats()
+
+	expectedStats := Stats{
+		Nodes:              4,
+		Edges:              2,
+		DegreeDistribution: map[int]int{0: 1, 1: 2, 2: 1},
+		AverageDegree:      1,
+		MaxDegree:          2,
+		IsolatedNodes:      []string{"0xD"},
+		Components:         2,
+	}
+
+	if !reflect.DeepEqual(stats, expectedStats) {
+		t.Errorf("invalid stats\nexpected: %+v\nactual:   %+v", expectedStats, stats)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := testGraph().Write(&buffer, "dot"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `graph keep {
+  "0xA";
+  "0xB";
+  "0xC";
+  "0xD";
+  "0xA" -- "0xB";
+  "0xA" -- "0xC";
+}
+`
+	if buffer.String() != expected {
+		t.Errorf("invalid dot\nexpected: %s\nactual:   %s", expected, buffer.String())
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := testGraph().Write(&buffer, "graphml"); err != nil {
+		t.Fatal(err)
+	}
+
+	document := graphML{}
+	if err := xml.Unmarshal(buffer.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(document.Graph.Nodes) != 4 {
+		t.Errorf("invalid nodes count\nexpected: %d\nactual:   %d", 4, len(document.Graph.Nodes))
+	}
+	expectedEdges := []graphMLEdge{
+		{Source: "0xA", Target: "0xB"},
+		{Source: "0xA", Target: "0xC"},
+	}
+	if !reflect.DeepEqual(document.Graph.Edges, expectedEdges) {
+		t.Errorf("invalid edges\nexpected: %v\nactual:   %v", expectedEdges, document.Graph.Edges)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := testGraph().Write(&buffer, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var document struct {
+		Nodes map[string][]string `json:"nodes"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedNodes := map[string][]string{
+		"0xA": {"0xB", "0xC"},
+		"0xB": {"0xA"},
+		"0xC": {"0xA"},
+		"0xD": {},
+	}
+	if !reflect.DeepEqual(document.Nodes, expectedNodes) {
+		t.Errorf("invalid nodes\nexpected: %v\nactual:   %v", expectedNodes, document.Nodes)
+	}
+}
+
+func TestWriteUnsupportedFormat(t *testing.T) {
+	if err := testGraph().Write(&bytes.Buffer{}, "svg"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestComponents(t *testing.T) {
+	g := testGraph()
+	g.AddEdge("0xE", "0xF")
+
+	expectedComponents := [][]string{
+		{"0xA", "0xB", "0xC"},
+		{"0xE", "0xF"},
+		{"0xD"},
+	}
+
+	if components := g.Components(); !reflect.DeepEqual(components, expectedComponents) {
+		t.Errorf("invalid components\nexpected: %v\nactual:   %v", expectedComponents, components)
+	}
+}
@@ -0,0 +1,193 @@
+// Package health exports gauges describing the health of the network as seen
+// by the discovery.
+package health
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "keep_sd"
+
+// Snapshot describes the network at the end of a discovery round.
+type Snapshot struct {
+	// Time is the time the discovery pass has completed at.
+	Time time.Time
+	// Sources is the number of configured diagnostics sources.
+	Sources int
+	// SourcePeers maps chain addresses of reachable sources to the number
+	// of peers they are connected to.
+	SourcePeers map[string]int
+	// Peers is the number of unique peers by chain address.
+	Peers int
+	// NetworkIDs is the number of unique network IDs of the peers.
+	NetworkIDs int
+	// NetworkIDConflicts is the number of peers reported with different
+	// network IDs by the sources.
+	NetworkIDConflicts int
+	// UnresolvedPeers is the number of peers without a reachable
+	// diagnostics endpoint.
+	UnresolvedPeers int
+	// Versions maps client versions to the number of peers running them.
+	Versions map[string]int
+	// ComponentSizes are sizes of the connected components of the
+	// connectivity graph, the largest first.
+	ComponentSizes []int
+	// IsolatedPeers is the number of peers without any connections.
+	IsolatedPeers int
+}
+
+// Metrics holds the network health gauges.
+type Metrics struct {
+	lastUpdate         prometheus.Gauge
+	sources            prometheus.Gauge
+	reachableSources   prometheus.Gauge
+	sourcePeers        *prometheus.GaugeVec
+	peers              prometheus.Gauge
+	networkIDs         prometheus.Gauge
+	networkIDConflicts prometheus.Gauge
+	unresolvedPeers    prometheus.Gauge
+	versionPeers       *prometheus.GaugeVec
+	components         prometheus.Gauge
+	componentPeers     *prometheus.GaugeVec
+	isolatedPeers      prometheus.Gauge
+	partitioned        prometheus.Gauge
+}
+
+// Gauge describes a gauge exported by the metrics.
+type Gauge struct {
+	// Name is the gauge's name without the namespace.
+	Name   string
+	Help   string
+	Labels []string
+}
+
+// FullName returns the gauge's name including the namespace.
+func (g Gauge) FullName() string {
+	return prometheus.BuildFQName(namespace, "", g.Name)
+}
+
+var (
+	lastUpdateGauge         = Gauge{Name: "last_update_timestamp_seconds", Help: "Unix time of the last completed discovery pass."}
+	sourcesGauge            = Gauge{Name: "sources", Help: "Number of configured diagnostics sources."}
+	reachableSourcesGauge   = Gauge{Name: "sources_reachable", Help: "Number of diagnostics sources reachable in the last round."}
+	sourcePeersGauge        = Gauge{Name: "source_peers", Help: "Number of peers connected to a diagnostics source.", Labels: []string{"source_chain_address"}}
+	peersGauge              = Gauge{Name: "peers", Help: "Number of unique peers by chain address."}
+	networkIDsGauge         = Gauge{Name: "peer_network_ids", Help: "Number of unique network IDs of the peers."}
+	networkIDConflictsGauge = Gauge{Name: "peer_network_id_conflicts", Help: "Number of peers reported with different network IDs by the sources."}
+	unresolvedPeersGauge    = Gauge{Name: "peers_unresolved", Help: "Number of peers without a reachable diagnostics endpoint."}
+	versionPeersGauge       = Gauge{Name: "version_peers", Help: "Number of peers running a client version.", Labels: []string{"version"}}
+	componentsGauge         = Gauge{Name: "graph_components", Help: "Number of connected components of the connectivity graph."}
+	componentPeersGauge     = Gauge{Name: "graph_component_peers", Help: "Number of peers in a connected component, ranked by size starting from 0.", Labels: []string{"rank"}}
+	isolatedPeersGauge      = Gauge{Name: "graph_isolated_peers", Help: "Number of peers without any connections."}
+	partitionedGauge        = Gauge{Name: "network_partitioned", Help: "Whether the connectivity graph has more than one connected component."}
+)
+
+// Gauges returns descriptions of all gauges exported by the metrics.
+func Gauges() []Gauge {
+	return []Gauge{
+		lastUpdateGauge,
+		sourcesGauge,
+		reachableSourcesGauge,
+		sourcePeersGauge,
+		peersGauge,
+		networkIDsGauge,
+		networkIDConflictsGauge,
+		unresolvedPeersGauge,
+		versionPeersGauge,
+		componentsGauge,
+		componentPeersGauge,
+		isolatedPeersGauge,
+		partitionedGauge,
+	}
+}
+
+// NewMetrics creates the gauges and registers them with the registerer.
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	gauge := func(g Gauge) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      g.Name,
+			Help:      g.Help,
+		})
+	}
+	gaugeVec := func(g Gauge) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      g.Name,
+			Help:      g.Help,
+		}, g.Labels)
+	}
+
+	m := &Metrics{
+		lastUpdate:         gauge(lastUpdateGauge),
+		sources:            gauge(sourcesGauge),
+		reachableSources:   gauge(reachableSourcesGauge),
+		sourcePeers:        gaugeVec(sourcePeersGauge),
+		peers:              gauge(peersGauge),
+		networkIDs:         gauge(networkIDsGauge),
+		networkIDConflicts: gauge(networkIDConflictsGauge),
+		unresolvedPeers:    gauge(unresolvedPeersGauge),
+		versionPeers:       gaugeVec(versionPeersGauge),
+		components:         gauge(componentsGauge),
+		componentPeers:     gaugeVec(componentPeersGauge),
+		isolatedPeers:      gauge(isolatedPeersGauge),
+		partitioned:        gauge(partitionedGauge),
+	}
+
+	registerer.MustRegister(
+		m.lastUpdate,
+		m.sources,
+		m.reachableSources,
+		m.sourcePeers,
+		m.peers,
+		m.networkIDs,
+		m.networkIDConflicts,
+		m.unresolvedPeers,
+		m.versionPeers,
+		m.components,
+		m.componentPeers,
+		m.isolatedPeers,
+		m.partitioned,
+	)
+
+	return m
+}
+
+// Update sets the gauges to the snapshot's values. Series of sources,
+// versions and components not present in the snapshot are removed.
+func (m *Metrics) Update(snapshot Snapshot) {
+	m.lastUpdate.Set(float64(snapshot.Time.UnixNano()) / 1e9)
+	m.sources.Set(float64(snapshot.Sources))
+	m.reachableSources.Set(float64(len(snapshot.SourcePeers)))
+
+	m.sourcePeers.Reset()
+	for source, peers := range snapshot.SourcePeers {
+		m.sourcePeers.WithLabelValues(source).Set(float64(peers))
+	}
+
+	m.peers.Set(float64(snapshot.Peers))
+	m.networkIDs.Set(float64(snapshot.NetworkIDs))
+	m.networkIDConflicts.Set(float64(snapshot.NetworkIDConflicts))
+	m.unresolvedPeers.Set(float64(snapshot.UnresolvedPeers))
+
+	m.versionPeers.Reset()
+	for version, peers := range snapshot.Versions {
+		m.versionPeers.WithLabelValues(version).Set(float64(peers))
+	}
+
+	m.components.Set(float64(len(snapshot.ComponentSizes)))
+	m.componentPeers.Reset()
+	for rank, size := range snapshot.ComponentSizes {
+		m.componentPeers.WithLabelValues(strconv.Itoa(rank)).Set(float64(size))
+	}
+	m.isolatedPeers.Set(float64(snapshot.IsolatedPeers))
+
+	partitioned := 0.0
+	if len(snapshot.ComponentSizes) > 1 {
+		partitioned = 1
+	}
+	m.partitioned.Set(partitioned)
+}
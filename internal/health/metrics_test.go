@@ -0,0 +1,72 @@
+package health
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdate(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+
+	metrics.Update(Snapshot{
+		Sources:         2,
+		SourcePeers:     map[string]int{"0xS1": 3, "0xS2": 1},
+		Peers:           4,
+		NetworkIDs:      4,
+		UnresolvedPeers: 1,
+		Versions:        map[string]int{"v2.0.0": 2, "unknown": 2},
+		ComponentSizes:  []int{4, 1},
+		IsolatedPeers:   1,
+	})
+
+	// A source and a version disappear in the next round.
+	metrics.Update(Snapshot{
+		Time:           time.Unix(1664582400, 500000000),
+		Sources:        2,
+		SourcePeers:    map[string]int{"0xS1": 4},
+		Peers:          4,
+		NetworkIDs:     4,
+		Versions:       map[string]int{"v2.0.0": 4},
+		ComponentSizes: []int{5},
+	})
+
+	expected := `
+# HELP keep_sd_graph_component_peers Number of peers in a connected component, ranked by size starting from 0.
+# TYPE keep_sd_graph_component_peers gauge
+keep_sd_graph_component_peers{rank="0"} 5
+# HELP keep_sd_last_update_timestamp_seconds Unix time of the last completed discovery pass.
+# TYPE keep_sd_last_update_timestamp_seconds gauge
+keep_sd_last_update_timestamp_seconds 1.6645824005e+09
+# HELP keep_sd_network_partitioned Whether the connectivity graph has more than one connected component.
+# TYPE keep_sd_network_partitioned gauge
+keep_sd_network_partitioned 0
+# HELP keep_sd_source_peers Number of peers connected to a diagnostics source.
+# TYPE keep_sd_source_peers gauge
+keep_sd_source_peers{source_chain_address="0xS1"} 4
+# HELP keep_sd_sources_reachable Number of diagnostics sources reachable in the last round.
+# TYPE keep_sd_sources_reachable gauge
+keep_sd_sources_reachable 1
+# HELP keep_sd_version_peers Number of peers running a client version.
+# TYPE keep_sd_version_peers gauge
+keep_sd_version_peers{version="v2.0.0"} 4
+`
+
+	err := testutil.GatherAndCompare(
+		registry,
+		strings.NewReader(expected),
+		"keep_sd_graph_component_peers",
+		"keep_sd_last_update_timestamp_seconds",
+		"keep_sd_network_partitioned",
+		"keep_sd_source_peers",
+		"keep_sd_sources_reachable",
+		"keep_sd_version_peers",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
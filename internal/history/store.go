@@ -0,0 +1,405 @@
+// Package history implements an embedded on-disk store recording the peers
+// seen in each discovery round.
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/exp/slices"
+)
+
+var (
+	roundsBucket = []byte("rounds")
+	peersBucket  = []byte("peers")
+
+	infoKey       = []byte("info")
+	seenBucket    = []byte("seen")
+	changesBucket = []byte("changes")
+)
+
+// openTimeout is the time to wait for a lock on the database file held by
+// another process.
+const openTimeout = 5 * time.Second
+
+// Observation is a state of a peer observed in a discovery round.
+type Observation struct {
+	ChainAddress     string   `json:"-"`
+	NetworkID        string   `json:"network_id"`
+	NetworkAddresses []string `json:"network_addresses"`
+	Endpoint         string   `json:"endpoint"`
+	Version          string   `json:"version"`
+}
+
+// equal reports whether the observations describe the same state of the peer.
+// Network addresses are compared regardless of their order, which follows the
+// address preference and can change between rounds, like with the number of
+// sources reporting each address.
+func (o Observation) equal(other Observation) bool {
+	return o.NetworkID == other.NetworkID &&
+		slices.Equal(sorted(o.NetworkAddresses), sorted(other.NetworkAddresses)) &&
+		o.Endpoint == other.Endpoint &&
+		o.Version == other.Version
+}
+
+// sorted returns a sorted copy of the values.
+func sorted(values []string) []string {
+	sortedValues := slices.Clone(values)
+	sort.Strings(sortedValues)
+	return sortedValues
+}
+
+// PeerInfo summarizes the history of a peer.
+type PeerInfo struct {
+	ChainAddress string    `json:"chain_address"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Change is a state of a peer recorded when it differs from the previous one.
+type Change struct {
+	Time time.Time `json:"time"`
+	Observation
+}
+
+// ChurnReport lists peers that joined or left the network in a time window.
+type ChurnReport struct {
+	From   time.Time  `json:"from"`
+	To     time.Time  `json:"to"`
+	Rounds int        `json:"rounds"`
+	Joined []PeerInfo `json:"joined"`
+	Left   []PeerInfo `json:"left"`
+}
+
+// Store is a peer history store backed by a bbolt database file.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the store at the given path, creating it if it doesn't exist
+// and the store is not read-only. The database file is locked until the store
+// is closed.
+func Open(path string, readOnly bool) (*Store, error) {
+	if readOnly {
+		// bbolt doesn't create the file in read-only mode and fails with
+		// a confusing error, so check it explicitly.
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout:  openTimeout,
+		ReadOnly: readOnly,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{roundsBucket, peersBucket} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize database: %w", err)
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the database file.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// RecordRound records the peers observed in a discovery round completed at
+// the given time.
+func (s *Store) RecordRound(at time.Time, observations []Observation) error {
+	key := timeKey(at)
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(roundsBucket).Put(key, []byte{}); err != nil {
+			return err
+		}
+
+		peers := tx.Bucket(peersBucket)
+		for _, observation := range observations {
+			peer, err := peers.CreateBucketIfNotExists([]byte(observation.ChainAddress))
+			if err != nil {
+				return err
+			}
+
+			info := PeerInfo{ChainAddress: observation.ChainAddress, FirstSeen: at}
+			if value := peer.Get(infoKey); value != nil {
+				if err := json.Unmarshal(value, &info); err != nil {
+					return fmt.Errorf("failed to decode peer info: %w", err)
+				}
+			}
+			info.LastSeen = at
+
+			value, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			if err := peer.Put(infoKey, value); err != nil {
+				return err
+			}
+
+			seen, err := peer.CreateBucketIfNotExists(seenBucket)
+			if err != nil {
+				return err
+			}
+			if err := seen.Put(key, []byte{}); err != nil {
+				return err
+			}
+
+			// Record the state only if it differs from the last recorded one.
+			changes, err := peer.CreateBucketIfNotExists(changesBucket)
+			if err != nil {
+				return err
+			}
+			if _, last := changes.Cursor().Last(); last != nil {
+				var previous Observation
+				if err := json.Unmarshal(last, &previous); err != nil {
+					return fmt.Errorf("failed to decode peer change: %w", err)
+				}
+				if previous.equal(observation) {
+					continue
+				}
+			}
+
+			value, err = json.Marshal(observation)
+			if err != nil {
+				return err
+			}
+			if err := changes.Put(key, value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Peers returns the summary of all recorded peers sorted by chain address.
+func (s *Store) Peers() ([]PeerInfo, error) {
+	result := make([]PeerInfo, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		peers := tx.Bucket(peersBucket)
+		if peers == nil {
+			return nil
+		}
+
+		return peers.ForEach(func(chainAddress, _ []byte) error {
+			info, err := peerInfo(peers.Bucket(chainAddress))
+			if err != nil {
+				return err
+			}
+			result = append(result, info)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// Peer returns the summary of the peer's history.
+func (s *Store) Peer(chainAddress string) (PeerInfo, error) {
+	var info PeerInfo
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		peer, err := peerBucket(tx, chainAddress)
+		if err != nil {
+			return err
+		}
+
+		info, err = peerInfo(peer)
+		return err
+	})
+
+	return info, err
+}
+
+// Changes returns the recorded states of the peer in chronological order.
+// A new state is recorded each time the peer's network ID, addresses,
+// endpoint or version change.
+func (s *Store) Changes(chainAddress string) ([]Change, error) {
+	result := make([]Change, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		peer, err := peerBucket(tx, chainAddress)
+		if err != nil {
+			return err
+		}
+
+		changes := peer.Bucket(changesBucket)
+		if changes == nil {
+			return nil
+		}
+
+		return changes.ForEach(func(key, value []byte) error {
+			change := Change{Time: keyTime(key)}
+			if err := json.Unmarshal(value, &change.Observation); err != nil {
+				return fmt.Errorf("failed to decode peer change: %w", err)
+			}
+			change.ChainAddress = chainAddress
+			result = append(result, change)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// Uptime returns the percentage of discovery rounds in the time window in
+// which the peer has been seen. Only rounds after the peer has been seen for
+// the first time are taken into account.
+func (s *Store) Uptime(chainAddress string, from, to time.Time) (float64, error) {
+	var uptime float64
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		peer, err := peerBucket(tx, chainAddress)
+		if err != nil {
+			return err
+		}
+
+		info, err := peerInfo(peer)
+		if err != nil {
+			return err
+		}
+		if info.FirstSeen.After(from) {
+			from = info.FirstSeen
+		}
+
+		rounds := countInRange(tx.Bucket(roundsBucket), from, to)
+		if rounds == 0 {
+			return nil
+		}
+
+		seen := countInRange(peer.Bucket(seenBucket), from, to)
+		uptime = 100 * float64(seen) / float64(rounds)
+		return nil
+	})
+
+	return uptime, err
+}
+
+// Churn returns peers that have been seen for the first time in the time
+// window and peers that have been seen for the last time in the time window,
+// but not in the last round of the window.
+func (s *Store) Churn(from, to time.Time) (*ChurnReport, error) {
+	report := &ChurnReport{
+		From:   from,
+		To:     to,
+		Joined: make([]PeerInfo, 0),
+		Left:   make([]PeerInfo, 0),
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		rounds := tx.Bucket(roundsBucket)
+		peers := tx.Bucket(peersBucket)
+		if rounds == nil || peers == nil {
+			return nil
+		}
+
+		report.Rounds = countInRange(rounds, from, to)
+
+		// Find the last round in the window.
+		var lastRound time.Time
+		cursor := rounds.Cursor()
+		for key, _ := cursor.Seek(timeKey(from)); key != nil && !keyTime(key).After(to); key, _ = cursor.Next() {
+			lastRound = keyTime(key)
+		}
+
+		return peers.ForEach(func(chainAddress, _ []byte) error {
+			info, err := peerInfo(peers.Bucket(chainAddress))
+			if err != nil {
+				return err
+			}
+
+			if inRange(info.FirstSeen, from, to) {
+				report.Joined = append(report.Joined, info)
+			}
+			if inRange(info.LastSeen, from, to) && info.LastSeen.Before(lastRound) {
+				report.Left = append(report.Left, info)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.Joined, func(i, j int) bool {
+		return report.Joined[i].FirstSeen.Before(report.Joined[j].FirstSeen)
+	})
+	sort.Slice(report.Left, func(i, j int) bool {
+		return report.Left[i].LastSeen.Before(report.Left[j].LastSeen)
+	})
+
+	return report, nil
+}
+
+func peerBucket(tx *bolt.Tx, chainAddress string) (*bolt.Bucket, error) {
+	peers := tx.Bucket(peersBucket)
+	if peers == nil {
+		return nil, fmt.Errorf("peer %s not found", chainAddress)
+	}
+
+	peer := peers.Bucket([]byte(chainAddress))
+	if peer == nil {
+		return nil, fmt.Errorf("peer %s not found", chainAddress)
+	}
+
+	return peer, nil
+}
+
+func peerInfo(peer *bolt.Bucket) (PeerInfo, error) {
+	var info PeerInfo
+	if err := json.Unmarshal(peer.Get(infoKey), &info); err != nil {
+		return info, fmt.Errorf("failed to decode peer info: %w", err)
+	}
+	return info, nil
+}
+
+func countInRange(bucket *bolt.Bucket, from, to time.Time) int {
+	if bucket == nil {
+		return 0
+	}
+
+	count := 0
+	cursor := bucket.Cursor()
+	for key, _ := cursor.Seek(timeKey(from)); key != nil && !keyTime(key).After(to); key, _ = cursor.Next() {
+		count++
+	}
+	return count
+}
+
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && !t.After(to)
+}
+
+// timeKey encodes the time as a big-endian key, so keys are sorted
+// chronologically.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))).UTC()
+}
@@ -0,0 +1,163 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestObservationEqual(t *testing.T) {
+	observation := Observation{
+		ChainAddress:     "0xA",
+		NetworkID:        "16Uiu2A",
+		NetworkAddresses: []string{"peer-a.example", "192.0.2.1", "2001:db8::1"},
+		Endpoint:         "peer-a.example:9601",
+		Version:          "v2.0.0",
+	}
+
+	reordered := observation
+	reordered.NetworkAddresses = []string{"2001:db8::1", "peer-a.example", "192.0.2.1"}
+
+	moved := observation
+	moved.NetworkAddresses = []string{"peer-a.example", "192.0.2.2", "2001:db8::1"}
+
+	removed := observation
+	removed.NetworkAddresses = []string{"peer-a.example", "192.0.2.1"}
+
+	var tests = map[string]struct {
+		other    Observation
+		expected bool
+	}{
+		"same":            {other: observation, expected: true},
+		"reordered":       {other: reordered, expected: true},
+		"changed address": {other: moved, expected: false},
+		"removed address": {other: removed, expected: false},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if equal := observation.equal(test.other); equal != test.expected {
+				t.Errorf("invalid equality\nexpected: %v\nactual:   %v", test.expected, equal)
+			}
+		})
+	}
+
+	// Comparison doesn't reorder the observed addresses.
+	if observation.NetworkAddresses[0] != "peer-a.example" || reordered.NetworkAddresses[0] != "2001:db8::1" {
+		t.Errorf("addresses reordered: %v, %v", observation.NetworkAddresses, reordered.NetworkAddresses)
+	}
+}
+
+func TestStore(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	round := func(i int) time.Time { return start.Add(time.Duration(i) * time.Hour) }
+
+	peerA := Observation{
+		ChainAddress:     "0xA",
+		NetworkID:        "16Uiu2A",
+		NetworkAddresses: []string{"10.0.0.1"},
+		Endpoint:         "10.0.0.1:9601",
+		Version:          "v2.0.0",
+	}
+	peerAMoved := peerA
+	peerAMoved.NetworkAddresses = []string{"10.0.0.2"}
+	peerAMoved.Endpoint = "10.0.0.2:9601"
+	peerB := Observation{
+		ChainAddress:     "0xB",
+		NetworkID:        "16Uiu2B",
+		NetworkAddresses: []string{"10.0.0.3"},
+		Endpoint:         "10.0.0.3:9601",
+		Version:          "v2.0.0",
+	}
+
+	rounds := [][]Observation{
+		{peerA},
+		{peerA, peerB},
+		{peerAMoved, peerB},
+		{peerAMoved},
+	}
+	for i, observations := range rounds {
+		if err := store.RecordRound(round(i), observations); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("peer", func(t *testing.T) {
+		info, err := store.Peer("0xB")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !info.FirstSeen.Equal(round(1)) {
+			t.Errorf("invalid first seen\nexpected: %v\nactual:   %v", round(1), info.FirstSeen)
+		}
+		if !info.LastSeen.Equal(round(2)) {
+			t.Errorf("invalid last seen\nexpected: %v\nactual:   %v", round(2), info.LastSeen)
+		}
+
+		if _, err := store.Peer("0xC"); err == nil {
+			t.Errorf("expected error for unknown peer")
+		}
+	})
+
+	t.Run("changes", func(t *testing.T) {
+		changes, err := store.Changes("0xA")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(changes) != 2 {
+			t.Fatalf("invalid number of changes\nexpected: %d\nactual:   %d", 2, len(changes))
+		}
+		if !changes[1].Time.Equal(round(2)) || changes[1].Endpoint != peerAMoved.Endpoint {
+			t.Errorf("invalid change\nexpected: %v %s\nactual:   %v %s",
+				round(2), peerAMoved.Endpoint, changes[1].Time, changes[1].Endpoint)
+		}
+	})
+
+	t.Run("uptime", func(t *testing.T) {
+		var tests = map[string]struct {
+			chainAddress   string
+			expectedUptime float64
+		}{
+			"always seen": {chainAddress: "0xA", expectedUptime: 100},
+			"seen once":   {chainAddress: "0xB", expectedUptime: 200.0 / 3},
+		}
+
+		for testName, test := range tests {
+			t.Run(testName, func(t *testing.T) {
+				uptime, err := store.Uptime(test.chainAddress, round(0), round(3))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if uptime != test.expectedUptime {
+					t.Errorf("invalid uptime\nexpected: %f\nactual:   %f", test.expectedUptime, uptime)
+				}
+			})
+		}
+	})
+
+	t.Run("churn", func(t *testing.T) {
+		report, err := store.Churn(round(1), round(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Rounds != 3 {
+			t.Errorf("invalid rounds\nexpected: %d\nactual:   %d", 3, report.Rounds)
+		}
+		if len(report.Joined) != 1 || report.Joined[0].ChainAddress != "0xB" {
+			t.Errorf("invalid joined peers: %v", report.Joined)
+		}
+		if len(report.Left) != 1 || report.Left[0].ChainAddress != "0xB" {
+			t.Errorf("invalid left peers: %v", report.Left)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// Package identity verifies identities of Keep Network peers.
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/multiformats/go-multistream"
+
+	"github.com/keep-network/prometheus-sd/internal/utils"
+)
+
+// HandshakeProtocolID is the multistream-select protocol ID of the security
+// transport used by Keep Network peers.
+const HandshakeProtocolID = "/keep/handshake/1.0.0"
+
+// Verifier confirms peer IDs of Keep Network peers with a libp2p handshake on
+// their network port.
+type Verifier struct {
+	transport *libp2ptls.Transport
+}
+
+// NewVerifier creates a verifier with an ephemeral identity.
+func NewVerifier() (*Verifier, error) {
+	privateKey, _, err := libp2pcrypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	transport, err := libp2ptls.New(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tls transport: %w", err)
+	}
+
+	return &Verifier{transport: transport}, nil
+}
+
+// VerifyPeerID connects to the peer's network address with the dialer and
+// performs the encryption part of the Keep Network handshake, in which the
+// peer proves it owns the key of the expected network ID. The authentication
+// part of the handshake, requiring an on-chain identity, is not performed.
+func (v *Verifier) VerifyPeerID(
+	ctx context.Context,
+	dialer utils.ContextDialer,
+	address string,
+	networkID string,
+) error {
+	expectedPeerID, err := peer.Decode(networkID)
+	if err != nil {
+		return fmt.Errorf("invalid network id %s: %w", networkID, err)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	// Abort the handshake when the context is done.
+	stop := closeOnDone(ctx, conn)
+	defer stop()
+
+	if err := multistream.SelectProtoOrFail(HandshakeProtocolID, conn); err != nil {
+		return fmt.Errorf("failed to negotiate handshake protocol: %w", err)
+	}
+
+	secureConn, err := v.transport.SecureOutbound(ctx, conn, expectedPeerID)
+	if err != nil {
+		return fmt.Errorf("failed to verify peer id: %w", err)
+	}
+	defer secureConn.Close()
+
+	if secureConn.RemotePeer() != expectedPeerID {
+		return fmt.Errorf(
+			"peer id mismatch: expected %s, got %s",
+			expectedPeerID,
+			secureConn.RemotePeer(),
+		)
+	}
+
+	return nil
+}
+
+// closeOnDone closes the connection when the context is done. The returned
+// function stops watching the context.
+func closeOnDone(ctx context.Context, conn net.Conn) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	return func() { close(done) }
+}
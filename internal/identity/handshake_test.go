@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	libp2pcrypto "github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/multiformats/go-multistream"
+)
+
+// startFakePeer starts a listener accepting the encryption part of the Keep
+// Network handshake and returns its address and network ID.
+func startFakePeer(t *testing.T) (string, string) {
+	privateKey, _, err := libp2pcrypto.GenerateSecp256k1Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err := libp2ptls.New(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				muxer := multistream.NewMultistreamMuxer()
+				muxer.AddHandler(HandshakeProtocolID, nil)
+				if _, _, err := muxer.Negotiate(conn); err != nil {
+					return
+				}
+
+				secureConn, err := transport.SecureInbound(context.Background(), conn, "")
+				if err != nil {
+					return
+				}
+				secureConn.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), peerID.String()
+}
+
+func TestVerifyPeerID(t *testing.T) {
+	address, networkID := startFakePeer(t)
+	_, otherNetworkID := startFakePeer(t)
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = map[string]struct {
+		networkID     string
+		expectedError bool
+	}{
+		"matching network id": {
+			networkID:     networkID,
+			expectedError: false,
+		},
+		"other peer's network id": {
+			networkID:     otherNetworkID,
+			expectedError: true,
+		},
+		"invalid network id": {
+			networkID:     "invalid",
+			expectedError: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			err := verifier.VerifyPeerID(ctx, &net.Dialer{}, address, test.networkID)
+
+			if test.expectedError && err == nil {
+				t.Errorf("expected error")
+			}
+			if !test.expectedError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
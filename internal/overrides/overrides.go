@@ -0,0 +1,118 @@
+// Package overrides configures fixed diagnostics endpoints and extra labels
+// for known peers, and static targets merged into the output.
+package overrides
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Override configures a peer identified by its chain address.
+type Override struct {
+	ChainAddress string `yaml:"chain_address"`
+	// Endpoint is the fixed diagnostics endpoint of the peer. The peer is
+	// not scanned if it is set.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Labels added to the peer's target.
+	Labels model.LabelSet `yaml:"labels,omitempty"`
+}
+
+// StaticTarget is a group of targets merged into the output as they are.
+type StaticTarget struct {
+	Targets []string       `yaml:"targets"`
+	Labels  model.LabelSet `yaml:"labels,omitempty"`
+}
+
+// Config holds overrides and static targets.
+type Config struct {
+	Overrides     []Override     `yaml:"overrides,omitempty"`
+	StaticTargets []StaticTarget `yaml:"static_targets,omitempty"`
+
+	byChainAddress map[string]*Override
+}
+
+// LoadConfig reads overrides and static targets from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse file %s: %w", path, err)
+	}
+
+	if err := cfg.init(); err != nil {
+		return nil, fmt.Errorf("invalid file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) init() error {
+	c.byChainAddress = make(map[string]*Override, len(c.Overrides))
+
+	for i := range c.Overrides {
+		override := &c.Overrides[i]
+
+		if override.ChainAddress == "" {
+			return fmt.Errorf("override %d: chain address is empty", i)
+		}
+		key := strings.ToLower(override.ChainAddress)
+		if _, ok := c.byChainAddress[key]; ok {
+			return fmt.Errorf("override %d: duplicated chain address %s", i, override.ChainAddress)
+		}
+
+		if override.Endpoint != "" {
+			if _, _, err := net.SplitHostPort(override.Endpoint); err != nil {
+				return fmt.Errorf("override %d: invalid endpoint %s: %w", i, override.Endpoint, err)
+			}
+		}
+
+		if err := validateLabels(override.Labels); err != nil {
+			return fmt.Errorf("override %d: %w", i, err)
+		}
+
+		c.byChainAddress[key] = override
+	}
+
+	for i, staticTarget := range c.StaticTargets {
+		if len(staticTarget.Targets) == 0 {
+			return fmt.Errorf("static target %d: targets are empty", i)
+		}
+		for _, target := range staticTarget.Targets {
+			if _, _, err := net.SplitHostPort(target); err != nil {
+				return fmt.Errorf("static target %d: invalid target %s: %w", i, target, err)
+			}
+		}
+
+		if err := validateLabels(staticTarget.Labels); err != nil {
+			return fmt.Errorf("static target %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func validateLabels(labels model.LabelSet) error {
+	if err := labels.Validate(); err != nil {
+		return err
+	}
+	if _, ok := labels[model.AddressLabel]; ok {
+		return fmt.Errorf("label %s cannot be overridden", model.AddressLabel)
+	}
+	return nil
+}
+
+// Lookup returns the override for the chain address. Chain addresses are
+// compared case-insensitively.
+func (c *Config) Lookup(chainAddress string) (*Override, bool) {
+	override, ok := c.byChainAddress[strings.ToLower(chainAddress)]
+	return override, ok
+}
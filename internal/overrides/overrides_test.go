@@ -0,0 +1,109 @@
+package overrides
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+const testConfig = `
+overrides:
+  - chain_address: "0xAbC1"
+    endpoint: node-1.keep.network:9601
+    labels:
+      owner: team-a
+      region: eu
+  - chain_address: "0xABC2"
+    labels:
+      owner: team-b
+static_targets:
+  - targets: ["10.0.0.5:9601"]
+    labels:
+      owner: team-c
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "overrides.yml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = map[string]struct {
+		chainAddress     string
+		expectedFound    bool
+		expectedEndpoint string
+		expectedOwner    model.LabelValue
+	}{
+		"exact case": {
+			chainAddress:     "0xAbC1",
+			expectedFound:    true,
+			expectedEndpoint: "node-1.keep.network:9601",
+			expectedOwner:    "team-a",
+		},
+		"different case": {
+			chainAddress:  "0xabc2",
+			expectedFound: true,
+			expectedOwner: "team-b",
+		},
+		"unknown": {
+			chainAddress:  "0xABC3",
+			expectedFound: false,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			override, found := cfg.Lookup(test.chainAddress)
+			if found != test.expectedFound {
+				t.Fatalf("invalid found\nexpected: %v\nactual:   %v", test.expectedFound, found)
+			}
+			if !found {
+				return
+			}
+
+			if override.Endpoint != test.expectedEndpoint {
+				t.Errorf(
+					"invalid endpoint\nexpected: %s\nactual:   %s",
+					test.expectedEndpoint,
+					override.Endpoint,
+				)
+			}
+			if owner := override.Labels["owner"]; owner != test.expectedOwner {
+				t.Errorf("invalid owner\nexpected: %s\nactual:   %s", test.expectedOwner, owner)
+			}
+		})
+	}
+
+	if len(cfg.StaticTargets) != 1 || cfg.StaticTargets[0].Targets[0] != "10.0.0.5:9601" {
+		t.Errorf("invalid static targets: %+v", cfg.StaticTargets)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	var tests = map[string]string{
+		"missing chain address":    "overrides:\n  - endpoint: node-1:9601\n",
+		"duplicated chain address": "overrides:\n  - chain_address: '0xA'\n  - chain_address: '0xa'\n",
+		"endpoint without port":    "overrides:\n  - chain_address: '0xA'\n    endpoint: node-1\n",
+		"invalid label name":       "overrides:\n  - chain_address: '0xA'\n    labels:\n      1owner: a\n",
+		"address label":            "static_targets:\n  - targets: ['node-1:9601']\n    labels:\n      __address__: a\n",
+		"empty static targets":     "static_targets:\n  - labels:\n      owner: a\n",
+	}
+
+	for testName, content := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, content)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
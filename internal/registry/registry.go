@@ -0,0 +1,202 @@
+// Package registry maps chain addresses of peers to metadata of their
+// operators kept in a local file.
+package registry
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const labelPrefix = model.MetaLabelPrefix + "keep_operator_"
+
+// LabelTags is the label holding the operator's tags.
+const LabelTags = labelPrefix + "tags"
+
+// Operator holds metadata of an operator running a peer.
+type Operator struct {
+	ChainAddress    string   `yaml:"chain_address" json:"chain_address"`
+	Name            string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Contact         string   `yaml:"contact,omitempty" json:"contact,omitempty"`
+	StakingProvider string   `yaml:"staking_provider,omitempty" json:"staking_provider,omitempty"`
+	Tags            []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// Labels returns the operator's metadata as __meta_keep_operator_* labels.
+// Empty fields are omitted. Tags are joined with commas and surrounded by
+// commas, so a tag can be matched with a regex like .*,tag,.* in relabeling.
+func (o *Operator) Labels() model.LabelSet {
+	labels := model.LabelSet{}
+
+	fields := map[string]string{
+		"name":             o.Name,
+		"contact":          o.Contact,
+		"staking_provider": o.StakingProvider,
+	}
+	for name, value := range fields {
+		if value != "" {
+			labels[model.LabelName(labelPrefix+name)] = model.LabelValue(value)
+		}
+	}
+
+	if len(o.Tags) > 0 {
+		labels[LabelTags] = model.LabelValue(
+			"," + strings.Join(o.Tags, ",") + ",",
+		)
+	}
+
+	return labels
+}
+
+// LabelNames returns names of all labels operators may be labeled with.
+func LabelNames() []model.LabelName {
+	return []model.LabelName{
+		labelPrefix + "name",
+		labelPrefix + "contact",
+		labelPrefix + "staking_provider",
+		LabelTags,
+	}
+}
+
+// Registry holds operators by chain addresses of their peers.
+type Registry struct {
+	byChainAddress map[string]*Operator
+}
+
+type registryFile struct {
+	Operators []*Operator `yaml:"operators" json:"operators"`
+}
+
+// Load reads the registry from a CSV, JSON or YAML file. The format is
+// determined by the file's extension.
+func Load(path string) (*Registry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var operators []*Operator
+	switch extension := strings.ToLower(filepath.Ext(path)); extension {
+	case ".csv":
+		operators, err = parseCSV(content)
+	case ".json":
+		operators, err = parseJSON(content)
+	case ".yml", ".yaml":
+		operators, err = parseYAML(content)
+	default:
+		return nil, fmt.Errorf("unsupported file extension: %s", extension)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file %s: %w", path, err)
+	}
+
+	registry := &Registry{byChainAddress: make(map[string]*Operator, len(operators))}
+	for i, operator := range operators {
+		if operator == nil || operator.ChainAddress == "" {
+			return nil, fmt.Errorf("operator %d: chain address is empty", i)
+		}
+
+		key := strings.ToLower(operator.ChainAddress)
+		if _, ok := registry.byChainAddress[key]; ok {
+			return nil, fmt.Errorf("operator %d: duplicated chain address %s", i, operator.ChainAddress)
+		}
+		registry.byChainAddress[key] = operator
+	}
+
+	return registry, nil
+}
+
+func parseJSON(content []byte) ([]*Operator, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+
+	file := &registryFile{}
+	if err := decoder.Decode(file); err != nil {
+		return nil, err
+	}
+
+	return file.Operators, nil
+}
+
+func parseYAML(content []byte) ([]*Operator, error) {
+	file := &registryFile{}
+	if err := yaml.UnmarshalStrict(content, file); err != nil {
+		return nil, err
+	}
+
+	return file.Operators, nil
+}
+
+// parseCSV parses a CSV file with a header row naming the columns. Tags are
+// separated with commas within a quoted field.
+func parseCSV(content []byte) ([]*Operator, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	setters := make([]func(*Operator, string), len(header))
+	for i, column := range header {
+		switch strings.TrimSpace(column) {
+		case "chain_address":
+			setters[i] = func(o *Operator, value string) { o.ChainAddress = value }
+		case "name":
+			setters[i] = func(o *Operator, value string) { o.Name = value }
+		case "contact":
+			setters[i] = func(o *Operator, value string) { o.Contact = value }
+		case "staking_provider":
+			setters[i] = func(o *Operator, value string) { o.StakingProvider = value }
+		case "tags":
+			setters[i] = func(o *Operator, value string) { o.Tags = splitTags(value) }
+		default:
+			return nil, fmt.Errorf("unknown column: %s", column)
+		}
+	}
+
+	operators := make([]*Operator, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		operator := &Operator{}
+		for i, value := range record {
+			setters[i](operator, strings.TrimSpace(value))
+		}
+		operators = append(operators, operator)
+	}
+
+	return operators, nil
+}
+
+func splitTags(value string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Lookup returns the operator of the peer with the chain address. Chain
+// addresses are compared case-insensitively.
+func (r *Registry) Lookup(chainAddress string) (*Operator, bool) {
+	operator, ok := r.byChainAddress[strings.ToLower(chainAddress)]
+	return operator, ok
+}
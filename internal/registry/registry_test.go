@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+var testFiles = map[string]string{
+	"operators.csv": `chain_address,name,contact,staking_provider,tags
+0xAbC1,Operator A,ops@a.example,0xSP1,"mainnet, eu"
+0xABC2,Operator B,,,
+`,
+	"operators.json": `{"operators": [
+  {"chain_address": "0xAbC1", "name": "Operator A", "contact": "ops@a.example", "staking_provider": "0xSP1", "tags": ["mainnet", "eu"]},
+  {"chain_address": "0xABC2", "name": "Operator B"}
+]}`,
+	"operators.yaml": `operators:
+  - chain_address: "0xAbC1"
+    name: Operator A
+    contact: ops@a.example
+    staking_provider: "0xSP1"
+    tags: [mainnet, eu]
+  - chain_address: "0xABC2"
+    name: Operator B
+`,
+}
+
+func TestLoad(t *testing.T) {
+	expectedLabels := map[string]model.LabelSet{
+		"0xabc1": {
+			"__meta_keep_operator_name":             "Operator A",
+			"__meta_keep_operator_contact":          "ops@a.example",
+			"__meta_keep_operator_staking_provider": "0xSP1",
+			"__meta_keep_operator_tags":             ",mainnet,eu,",
+		},
+		"0xAbC2": {
+			"__meta_keep_operator_name": "Operator B",
+		},
+	}
+
+	for fileName, content := range testFiles {
+		t.Run(fileName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), fileName)
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			registry, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for chainAddress, expected := range expectedLabels {
+				operator, ok := registry.Lookup(chainAddress)
+				if !ok {
+					t.Fatalf("operator %s not found", chainAddress)
+				}
+				if labels := operator.Labels(); !reflect.DeepEqual(labels, expected) {
+					t.Errorf("invalid labels\nexpected: %v\nactual:   %v", expected, labels)
+				}
+			}
+
+			if _, ok := registry.Lookup("0xABC3"); ok {
+				t.Errorf("unexpected operator found")
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	var tests = map[string]string{
+		"operators.csv":  "chain_address,unknown\n0xA,value\n",
+		"operators.json": `{"operators": [{"chain_address": "0xA", "unknown": "value"}]}`,
+		"operators.yml":  "operators:\n  - chain_address: '0xA'\n  - chain_address: '0xa'\n",
+		"operators.txt":  "0xA",
+	}
+
+	for fileName, content := range tests {
+		t.Run(fileName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), fileName)
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := Load(path); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestLabelNames(t *testing.T) {
+	operator := &Operator{
+		ChainAddress:    "0xA",
+		Name:            "Operator A",
+		Contact:         "ops@a.example",
+		StakingProvider: "0xSP1",
+		Tags:            []string{"mainnet"},
+	}
+
+	names := make(map[model.LabelName]bool)
+	for _, name := range LabelNames() {
+		names[name] = true
+	}
+
+	labels := operator.Labels()
+	for name := range labels {
+		if !names[name] {
+			t.Errorf("label %s is missing in label names", name)
+		}
+	}
+	if len(labels) != len(names) {
+		t.Errorf("expected %d labels, got %d", len(names), len(labels))
+	}
+}
@@ -0,0 +1,65 @@
+// Package relabeling applies Prometheus relabeling rules to targets before
+// they are exported.
+package relabeling
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds relabeling rules in the format of Prometheus' scrape config
+// relabel_configs section.
+type Config struct {
+	RelabelConfigs []*relabel.Config `yaml:"relabel_configs,omitempty"`
+}
+
+// LoadConfig reads relabeling rules from a YAML file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse file %s: %w", path, err)
+	}
+
+	for i, relabelConfig := range cfg.RelabelConfigs {
+		if relabelConfig == nil {
+			return nil, fmt.Errorf("relabel config %d is empty", i)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Process applies the rules to the label set. It returns false if the label
+// set has been dropped by a keep or drop action.
+func Process(labelSet model.LabelSet, cfgs []*relabel.Config) (model.LabelSet, bool) {
+	if len(cfgs) == 0 {
+		return labelSet, true
+	}
+
+	labelsMap := make(map[string]string, len(labelSet))
+	for name, value := range labelSet {
+		labelsMap[string(name)] = string(value)
+	}
+
+	processed := relabel.Process(labels.FromMap(labelsMap), cfgs...)
+	if processed == nil {
+		return nil, false
+	}
+
+	result := make(model.LabelSet, len(processed))
+	for _, label := range processed {
+		result[model.LabelName(label.Name)] = model.LabelValue(label.Value)
+	}
+
+	return result, true
+}
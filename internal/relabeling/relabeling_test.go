@@ -0,0 +1,89 @@
+package relabeling
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+const testConfig = `
+relabel_configs:
+  - source_labels: [__meta_chain_address]
+    regex: "0xBAD.*"
+    action: drop
+  - source_labels: [__meta_chain_address]
+    target_label: chain_address
+  - regex: __meta_network_id
+    action: labeldrop
+`
+
+func TestProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yml")
+	if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = map[string]struct {
+		labelSet         model.LabelSet
+		expectedLabelSet model.LabelSet
+		expectedKeep     bool
+	}{
+		"relabeled": {
+			labelSet: model.LabelSet{
+				"__address__":          "node-1.keep.network:9601",
+				"__meta_chain_address": "0xA1",
+				"__meta_network_id":    "16Uiu2",
+			},
+			expectedLabelSet: model.LabelSet{
+				"__address__":          "node-1.keep.network:9601",
+				"__meta_chain_address": "0xA1",
+				"chain_address":        "0xA1",
+			},
+			expectedKeep: true,
+		},
+		"dropped": {
+			labelSet: model.LabelSet{
+				"__address__":          "node-2.keep.network:9601",
+				"__meta_chain_address": "0xBAD1",
+			},
+			expectedKeep: false,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			labelSet, keep := Process(test.labelSet, cfg.RelabelConfigs)
+
+			if keep != test.expectedKeep {
+				t.Fatalf("invalid keep\nexpected: %v\nactual:   %v", test.expectedKeep, keep)
+			}
+			if keep && !reflect.DeepEqual(labelSet, test.expectedLabelSet) {
+				t.Errorf(
+					"invalid labels\nexpected: %v\nactual:   %v",
+					test.expectedLabelSet,
+					labelSet,
+				)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidAction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relabel.yml")
+	content := "relabel_configs:\n  - action: unknown\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("expected error")
+	}
+}
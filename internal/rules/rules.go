@@ -0,0 +1,240 @@
+// Package rules generates Prometheus alerting and recording rules for the
+// targets exported by the discovery.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Names of the target labels the discovery's meta labels are expected to be
+// relabeled to, as in the example Prometheus configuration.
+const (
+	LabelChainAddress = "chain_address"
+	LabelNetworkID    = "network_id"
+	LabelVersion      = "version"
+	LabelEndpointKind = "endpoint_kind"
+)
+
+// Names of the recording rules.
+const (
+	RecordPeers          = "keep_network:peers:count"
+	RecordPeersUp        = "keep_network:peers_up:count"
+	RecordVersionPeersUp = "keep_network:peers_up:count_by_version"
+)
+
+// Config tunes the generated rules.
+type Config struct {
+	// NodesJob is the name of the job scraping the discovered nodes.
+	NodesJob string
+	// SDJob is the name of the job scraping the discovery's own metrics.
+	SDJob string
+	// EndpointKind is the kind of the nodes' targets the rules consider if
+	// peers have a target per endpoint path, so each peer counts once and
+	// targets of endpoints not serving metrics don't fire alerts. Targets
+	// without a kind, exported for peers with a single target, are always
+	// considered. All targets are considered if empty.
+	EndpointKind string
+
+	// PeerDownFor is the time a peer has to be down before it is alerted on.
+	PeerDownFor time.Duration
+	// VersionLagFor is the time a peer has to run a lagging version before
+	// it is alerted on.
+	VersionLagFor time.Duration
+	// ExpectedVersion is the client version peers are expected to run. If
+	// empty, peers are expected to run the version most peers run, or any of
+	// the versions most peers run if there's a tie.
+	ExpectedVersion string
+	// PeerDropThreshold is the fraction of peers that can disappear compared
+	// to the maximum of the last hour before it is alerted on.
+	PeerDropThreshold float64
+	// StalenessThreshold is the time after which the discovery's output is
+	// considered stale if no instance of the discovery has completed a pass.
+	StalenessThreshold time.Duration
+}
+
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Record      string            `yaml:"record,omitempty"`
+	Alert       string            `yaml:"alert,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Generate returns the rules file in the Prometheus format.
+func Generate(cfg Config) ([]byte, error) {
+	if cfg.NodesJob == "" || cfg.SDJob == "" {
+		return nil, fmt.Errorf("job names are required")
+	}
+	if cfg.PeerDropThreshold <= 0 || cfg.PeerDropThreshold >= 1 {
+		return nil, fmt.Errorf("peer drop threshold must be between 0 and 1")
+	}
+	if cfg.StalenessThreshold <= 0 {
+		return nil, fmt.Errorf("staleness threshold must be positive")
+	}
+	if cfg.PeerDownFor <= 0 || cfg.VersionLagFor <= 0 {
+		// Without the pending period, a single failed scrape or a peer in the
+		// middle of an upgrade fires the alerts.
+		return nil, fmt.Errorf("peer down and version lag durations must be positive")
+	}
+
+	nodesUp := nodesSelector(cfg)
+	lastUpdate := fmt.Sprintf(`keep_sd_last_update_timestamp_seconds{job=%q}`, cfg.SDJob)
+
+	// Versions run by as many peers as the most common one are all expected,
+	// so peers don't alternate between lagging and not on ties, like in the
+	// middle of a rollout.
+	laggingPeers := fmt.Sprintf(
+		"(%s == 1) unless on (%s) (%s == scalar(max(%s)))",
+		nodesUp,
+		LabelVersion,
+		RecordVersionPeersUp,
+		RecordVersionPeersUp,
+	)
+	laggingDescription := fmt.Sprintf(
+		"Peer {{ $labels.%s }} runs version {{ $labels.%s }} while more peers run a different one.",
+		LabelChainAddress,
+		LabelVersion,
+	)
+	if cfg.ExpectedVersion != "" {
+		laggingPeers = fmt.Sprintf(
+			"%s == 1",
+			nodesSelector(cfg, fmt.Sprintf("%s!=%q", LabelVersion, cfg.ExpectedVersion)),
+		)
+		laggingDescription = fmt.Sprintf(
+			"Peer {{ $labels.%s }} runs version {{ $labels.%s }} instead of %s.",
+			LabelChainAddress,
+			LabelVersion,
+			cfg.ExpectedVersion,
+		)
+	}
+
+	groups := ruleGroups{
+		Groups: []ruleGroup{
+			{
+				Name: "keep-network-nodes.rules",
+				Rules: []rule{
+					{
+						Record: RecordPeers,
+						Expr:   fmt.Sprintf("count(%s)", nodesUp),
+					},
+					{
+						Record: RecordPeersUp,
+						Expr:   fmt.Sprintf("count(%s == 1)", nodesUp),
+					},
+					{
+						Record: RecordVersionPeersUp,
+						Expr:   fmt.Sprintf("count by (%s) (%s == 1)", LabelVersion, nodesUp),
+					},
+				},
+			},
+			{
+				Name: "keep-network-nodes.alerts",
+				Rules: []rule{
+					{
+						Alert:  "KeepPeerDown",
+						Expr:   fmt.Sprintf("%s == 0", nodesUp),
+						For:    formatDuration(cfg.PeerDownFor),
+						Labels: map[string]string{"severity": "warning"},
+						Annotations: map[string]string{
+							"summary": fmt.Sprintf("Peer {{ $labels.%s }} is down", LabelChainAddress),
+							"description": fmt.Sprintf(
+								"Peer {{ $labels.%s }} with network ID {{ $labels.%s }} has not been scraped successfully at {{ $labels.instance }} for %s.",
+								LabelChainAddress,
+								LabelNetworkID,
+								formatDuration(cfg.PeerDownFor),
+							),
+						},
+					},
+					{
+						Alert:  "KeepPeerVersionLagging",
+						Expr:   laggingPeers,
+						For:    formatDuration(cfg.VersionLagFor),
+						Labels: map[string]string{"severity": "info"},
+						Annotations: map[string]string{
+							"summary":     fmt.Sprintf("Peer {{ $labels.%s }} runs a lagging version", LabelChainAddress),
+							"description": laggingDescription,
+						},
+					},
+					{
+						Alert: "KeepNetworkPeerCountDrop",
+						Expr: fmt.Sprintf(
+							"%s < %g * max_over_time(%s[1h])",
+							RecordPeers,
+							1-cfg.PeerDropThreshold,
+							RecordPeers,
+						),
+						Labels: map[string]string{"severity": "warning"},
+						Annotations: map[string]string{
+							"summary": "Network peer count dropped",
+							"description": fmt.Sprintf(
+								"The number of discovered peers dropped to {{ $value }}, by more than %g%% of the last hour's maximum.",
+								100*cfg.PeerDropThreshold,
+							),
+						},
+					},
+				},
+			},
+			{
+				Name: "keep-sd.alerts",
+				Rules: []rule{
+					{
+						Alert: "KeepSDOutputStale",
+						Expr: fmt.Sprintf(
+							"time() - max(%s) > %g or absent(%s)",
+							lastUpdate,
+							cfg.StalenessThreshold.Seconds(),
+							lastUpdate,
+						),
+						For:    "5m",
+						Labels: map[string]string{"severity": "critical"},
+						Annotations: map[string]string{
+							"summary": "Discovery output is stale",
+							"description": fmt.Sprintf(
+								"The discovery has not completed a pass for more than %s, so discovered targets may be outdated.",
+								formatDuration(cfg.StalenessThreshold),
+							),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return yaml.Marshal(groups)
+}
+
+// nodesSelector returns the selector of the up metric of the nodes' targets
+// considered by the rules, with the additional matchers.
+func nodesSelector(cfg Config, matchers ...string) string {
+	matchers = append([]string{fmt.Sprintf("job=%q", cfg.NodesJob)}, matchers...)
+	if cfg.EndpointKind != "" {
+		matchers = append(matchers, fmt.Sprintf(
+			"%s=~%q",
+			LabelEndpointKind,
+			"|"+regexp.QuoteMeta(cfg.EndpointKind),
+		))
+	}
+
+	return fmt.Sprintf("up{%s}", strings.Join(matchers, ", "))
+}
+
+// formatDuration formats the duration in the Prometheus format.
+func formatDuration(d time.Duration) string {
+	return model.Duration(d).String()
+}
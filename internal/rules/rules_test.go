@@ -0,0 +1,274 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+)
+
+var testConfig = Config{
+	NodesJob:           "keep-network-nodes",
+	SDJob:              "keep-sd",
+	PeerDownFor:        5 * time.Minute,
+	VersionLagFor:      24 * time.Hour,
+	PeerDropThreshold:  0.2,
+	StalenessThreshold: 15 * time.Minute,
+}
+
+type expectedRule struct {
+	name string
+	expr string
+	for_ time.Duration
+}
+
+// expectedRecordingRules returns the recording rules expected for the
+// selector of the nodes' up metric.
+func expectedRecordingRules(nodesUp string) []expectedRule {
+	return []expectedRule{
+		{
+			name: RecordPeers,
+			expr: fmt.Sprintf("count(%s)", nodesUp),
+		},
+		{
+			name: RecordPeersUp,
+			expr: fmt.Sprintf("count(%s == 1)", nodesUp),
+		},
+		{
+			name: RecordVersionPeersUp,
+			expr: fmt.Sprintf("count by (version) (%s == 1)", nodesUp),
+		},
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var tests = map[string]struct {
+		config          func(cfg *Config)
+		expectedNodesUp string
+		expectedRules   []expectedRule
+	}{
+		"most common version": {
+			config:          func(cfg *Config) {},
+			expectedNodesUp: `up{job="keep-network-nodes"}`,
+			expectedRules: []expectedRule{
+				{
+					name: "KeepPeerDown",
+					expr: `up{job="keep-network-nodes"} == 0`,
+					for_: 5 * time.Minute,
+				},
+				{
+					name: "KeepPeerVersionLagging",
+					expr: `(up{job="keep-network-nodes"} == 1)
+						unless on (version)
+						(keep_network:peers_up:count_by_version == scalar(max(keep_network:peers_up:count_by_version)))`,
+					for_: 24 * time.Hour,
+				},
+				{
+					name: "KeepNetworkPeerCountDrop",
+					expr: `keep_network:peers:count < 0.8 * max_over_time(keep_network:peers:count[1h])`,
+				},
+				{
+					name: "KeepSDOutputStale",
+					expr: `time() - max(keep_sd_last_update_timestamp_seconds{job="keep-sd"}) > 900
+						or absent(keep_sd_last_update_timestamp_seconds{job="keep-sd"})`,
+					for_: 5 * time.Minute,
+				},
+			},
+		},
+		"expected version": {
+			config: func(cfg *Config) {
+				cfg.ExpectedVersion = "v2.0.0"
+				cfg.VersionLagFor = time.Hour
+			},
+			expectedNodesUp: `up{job="keep-network-nodes"}`,
+			expectedRules: []expectedRule{
+				{
+					name: "KeepPeerDown",
+					expr: `up{job="keep-network-nodes"} == 0`,
+					for_: 5 * time.Minute,
+				},
+				{
+					name: "KeepPeerVersionLagging",
+					expr: `up{job="keep-network-nodes", version!="v2.0.0"} == 1`,
+					for_: time.Hour,
+				},
+				{
+					name: "KeepNetworkPeerCountDrop",
+					expr: `keep_network:peers:count < 0.8 * max_over_time(keep_network:peers:count[1h])`,
+				},
+				{
+					name: "KeepSDOutputStale",
+					expr: `time() - max(keep_sd_last_update_timestamp_seconds{job="keep-sd"}) > 900
+						or absent(keep_sd_last_update_timestamp_seconds{job="keep-sd"})`,
+					for_: 5 * time.Minute,
+				},
+			},
+		},
+		"endpoint kind": {
+			config: func(cfg *Config) {
+				cfg.EndpointKind = "metrics"
+				cfg.ExpectedVersion = "v2.0.0"
+			},
+			expectedNodesUp: `up{job="keep-network-nodes", endpoint_kind=~"|metrics"}`,
+			expectedRules: []expectedRule{
+				{
+					name: "KeepPeerDown",
+					expr: `up{job="keep-network-nodes", endpoint_kind=~"|metrics"} == 0`,
+					for_: 5 * time.Minute,
+				},
+				{
+					name: "KeepPeerVersionLagging",
+					expr: `up{job="keep-network-nodes", version!="v2.0.0", endpoint_kind=~"|metrics"} == 1`,
+					for_: 24 * time.Hour,
+				},
+				{
+					name: "KeepNetworkPeerCountDrop",
+					expr: `keep_network:peers:count < 0.8 * max_over_time(keep_network:peers:count[1h])`,
+				},
+				{
+					name: "KeepSDOutputStale",
+					expr: `time() - max(keep_sd_last_update_timestamp_seconds{job="keep-sd"}) > 900
+						or absent(keep_sd_last_update_timestamp_seconds{job="keep-sd"})`,
+					for_: 5 * time.Minute,
+				},
+			},
+		},
+		"endpoint kind with regex characters": {
+			config: func(cfg *Config) {
+				cfg.EndpointKind = "metrics.v2"
+				cfg.ExpectedVersion = "v2.0.0"
+			},
+			expectedNodesUp: `up{job="keep-network-nodes", endpoint_kind=~"|metrics\\.v2"}`,
+			expectedRules: []expectedRule{
+				{
+					name: "KeepPeerDown",
+					expr: `up{job="keep-network-nodes", endpoint_kind=~"|metrics\\.v2"} == 0`,
+					for_: 5 * time.Minute,
+				},
+				{
+					name: "KeepPeerVersionLagging",
+					expr: `up{job="keep-network-nodes", version!="v2.0.0", endpoint_kind=~"|metrics\\.v2"} == 1`,
+					for_: 24 * time.Hour,
+				},
+				{
+					name: "KeepNetworkPeerCountDrop",
+					expr: `keep_network:peers:count < 0.8 * max_over_time(keep_network:peers:count[1h])`,
+				},
+				{
+					name: "KeepSDOutputStale",
+					expr: `time() - max(keep_sd_last_update_timestamp_seconds{job="keep-sd"}) > 900
+						or absent(keep_sd_last_update_timestamp_seconds{job="keep-sd"})`,
+					for_: 5 * time.Minute,
+				},
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := testConfig
+			test.config(&cfg)
+
+			content, err := Generate(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rules := parseRules(t, content)
+			expectedRules := append(expectedRecordingRules(test.expectedNodesUp), test.expectedRules...)
+
+			names := make([]string, 0, len(rules))
+			for _, rule := range rules {
+				names = append(names, rule.Record+rule.Alert)
+			}
+			expectedNames := make([]string, 0, len(expectedRules))
+			for _, rule := range expectedRules {
+				expectedNames = append(expectedNames, rule.name)
+			}
+			if !reflect.DeepEqual(names, expectedNames) {
+				t.Fatalf("unexpected rules\nexpected: %v\nactual:   %v", expectedNames, names)
+			}
+
+			for i, expected := range expectedRules {
+				assertExpr(t, expected.name, rules[i].Expr, expected.expr)
+
+				var pendingPeriod model.Duration
+				if rules[i].For != "" {
+					pendingPeriod, err = model.ParseDuration(rules[i].For)
+					if err != nil {
+						t.Errorf("invalid pending period of rule %s: %v", expected.name, err)
+					}
+				}
+				if pendingPeriod != model.Duration(expected.for_) {
+					t.Errorf(
+						"unexpected pending period of rule %s\nexpected: %s\nactual:   %s",
+						expected.name,
+						model.Duration(expected.for_),
+						pendingPeriod,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateInvalidConfig(t *testing.T) {
+	var tests = map[string]func(cfg *Config){
+		"missing job":                func(cfg *Config) { cfg.SDJob = "" },
+		"peer drop threshold over 1": func(cfg *Config) { cfg.PeerDropThreshold = 1.5 },
+		"zero staleness threshold":   func(cfg *Config) { cfg.StalenessThreshold = 0 },
+		"zero peer down duration":    func(cfg *Config) { cfg.PeerDownFor = 0 },
+		"zero version lag duration":  func(cfg *Config) { cfg.VersionLagFor = 0 },
+	}
+
+	for testName, config := range tests {
+		t.Run(testName, func(t *testing.T) {
+			cfg := testConfig
+			config(&cfg)
+
+			if _, err := Generate(cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// parseRules parses the rules file strictly and returns the rules of all
+// groups.
+func parseRules(t *testing.T, content []byte) []rule {
+	t.Helper()
+
+	var groups ruleGroups
+	if err := yaml.UnmarshalStrict(content, &groups); err != nil {
+		t.Fatalf("failed to parse rules: %v\n%s", err, content)
+	}
+
+	rules := make([]rule, 0)
+	for _, group := range groups.Groups {
+		rules = append(rules, group.Rules...)
+	}
+	return rules
+}
+
+// assertExpr checks the rule's expression is equivalent to the expected one,
+// comparing their syntax trees rather than formatting.
+func assertExpr(t *testing.T, name, actual, expected string) {
+	t.Helper()
+
+	expectedExpr, err := parser.ParseExpr(expected)
+	if err != nil {
+		t.Fatalf("invalid expected expression of rule %s: %v", name, err)
+	}
+	actualExpr, err := parser.ParseExpr(actual)
+	if err != nil {
+		t.Fatalf("invalid expression of rule %s: %v", name, err)
+	}
+
+	if actualExpr.String() != expectedExpr.String() {
+		t.Errorf("unexpected expression of rule %s\nexpected: %s\nactual:   %s", name, expectedExpr, actualExpr)
+	}
+}
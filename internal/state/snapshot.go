@@ -0,0 +1,69 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Version is the version of the snapshot format written by this package.
+// It is increased on incompatible changes of the format.
+const Version = 1
+
+// Snapshot is a versioned copy of the discovery state.
+type Snapshot struct {
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	Sources   []Source   `json:"sources"`
+	Peers     []Peer     `json:"peers"`
+	Ports     []PortStat `json:"ports"`
+}
+
+// Write writes the snapshot as JSON.
+func Write(w io.Writer, snapshot *Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
+
+// Read reads a JSON snapshot. Snapshots of other versions are rejected.
+func Read(r io.Reader) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	if snapshot.Version != Version {
+		return nil, fmt.Errorf(
+			"unsupported snapshot version %d; expected %d",
+			snapshot.Version,
+			Version,
+		)
+	}
+
+	for i, peer := range snapshot.Peers {
+		if peer.ChainAddress == "" {
+			return nil, fmt.Errorf("peer %d: chain address is missing", i)
+		}
+	}
+	for i, source := range snapshot.Sources {
+		if source.Address == "" {
+			return nil, fmt.Errorf("source %d: address is missing", i)
+		}
+	}
+
+	return &snapshot, nil
+}
+
+// Load reads the snapshot from the file.
+func Load(file string) (*Snapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
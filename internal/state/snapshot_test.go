@@ -0,0 +1,77 @@
+package state
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadWrite(t *testing.T) {
+	snapshot := &Snapshot{
+		Version:   Version,
+		CreatedAt: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC),
+		Sources:   []Source{{Address: "bootstrap:9601", ChainAddress: "0xS", Peers: 1}},
+		Peers: []Peer{
+			{
+				ChainAddress:     "0xA",
+				NetworkID:        "16Uiu2A",
+				NetworkAddresses: []string{"10.0.0.1"},
+				NetworkPort:      3919,
+				Endpoint:         "10.0.0.1:9601",
+			},
+		},
+		Ports: []PortStat{{Port: 9601, Found: 1}},
+	}
+
+	var buffer bytes.Buffer
+	if err := Write(&buffer, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := Read(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, snapshot) {
+		t.Errorf("unexpected snapshot\nexpected: %+v\nactual:   %+v", snapshot, actual)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := map[string]struct {
+		input         string
+		expectedError string
+	}{
+		"malformed": {
+			input:         `{"version": 1`,
+			expectedError: "failed to decode snapshot",
+		},
+		"missing version": {
+			input:         `{"peers": []}`,
+			expectedError: "unsupported snapshot version 0",
+		},
+		"newer version": {
+			input:         `{"version": 2}`,
+			expectedError: "unsupported snapshot version 2",
+		},
+		"peer without chain address": {
+			input:         `{"version": 1, "peers": [{"endpoint": "10.0.0.1:9601"}]}`,
+			expectedError: "peer 0: chain address is missing",
+		},
+		"source without address": {
+			input:         `{"version": 1, "sources": [{"chain_address": "0xS"}]}`,
+			expectedError: "source 0: address is missing",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(test.input))
+			if err == nil || !strings.Contains(err.Error(), test.expectedError) {
+				t.Errorf("expected error containing %q; got %v", test.expectedError, err)
+			}
+		})
+	}
+}
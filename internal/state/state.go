@@ -0,0 +1,230 @@
+// Package state keeps the discovery state carried over between discovery
+// rounds and implements its versioned snapshots, so an instance can start
+// with a warm cache instead of rescanning the whole network.
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Source is the state of a diagnostics source.
+type Source struct {
+	Address      string `json:"address"`
+	ChainAddress string `json:"chain_address"`
+	// Number of peers reported by the source in the last successful call.
+	Peers       int       `json:"peers"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	// Number of consecutive failed calls.
+	Failures int `json:"failures"`
+}
+
+// Peer is the state of a peer reported by the sources.
+type Peer struct {
+	ChainAddress     string   `json:"chain_address"`
+	NetworkID        string   `json:"network_id"`
+	NetworkAddresses []string `json:"network_addresses"`
+	NetworkPort      int      `json:"network_port"`
+	// Last resolved diagnostics endpoint; kept when the resolution fails, so
+	// it is tried first in the next round.
+	Endpoint     string    `json:"endpoint"`
+	Version      string    `json:"version"`
+	LastSeen     time.Time `json:"last_seen"`
+	LastResolved time.Time `json:"last_resolved"`
+	// Number of consecutive failed resolutions.
+	Failures int `json:"failures"`
+}
+
+// PortStat counts diagnostics endpoints found on a port by scanning.
+type PortStat struct {
+	Port  int `json:"port"`
+	Found int `json:"found"`
+}
+
+// State is the discovery state. It is safe for concurrent use.
+type State struct {
+	mutex   sync.RWMutex
+	sources map[string]*Source
+	peers   map[string]*Peer
+	ports   map[int]int
+}
+
+// New creates an empty state.
+func New() *State {
+	return &State{
+		sources: make(map[string]*Source),
+		peers:   make(map[string]*Peer),
+		ports:   make(map[int]int),
+	}
+}
+
+// RecordSource records the outcome of a call to the source. The chain address
+// and the number of peers are ignored for a failed call.
+func (s *State) RecordSource(address, chainAddress string, peers int, ok bool, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source, exists := s.sources[address]
+	if !exists {
+		source = &Source{Address: address}
+		s.sources[address] = source
+	}
+
+	source.LastAttempt = at
+	if !ok {
+		source.Failures++
+		return
+	}
+
+	source.ChainAddress = chainAddress
+	source.Peers = peers
+	source.LastSuccess = at
+	source.Failures = 0
+}
+
+// RecordPeer records the peer seen in a round and the outcome of its
+// resolution. The endpoint and the version are ignored if the peer has not
+// been resolved.
+func (s *State) RecordPeer(peer Peer, resolved bool, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, exists := s.peers[peer.ChainAddress]
+	if !exists {
+		current = &Peer{ChainAddress: peer.ChainAddress}
+		s.peers[peer.ChainAddress] = current
+	}
+
+	current.NetworkID = peer.NetworkID
+	current.NetworkAddresses = peer.NetworkAddresses
+	current.NetworkPort = peer.NetworkPort
+	current.LastSeen = at
+	if !resolved {
+		current.Failures++
+		return
+	}
+
+	current.Endpoint = peer.Endpoint
+	current.Version = peer.Version
+	current.LastResolved = at
+	current.Failures = 0
+}
+
+// RecordPortFound counts a diagnostics endpoint found on the port by scanning.
+func (s *State) RecordPortFound(port int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ports[port]++
+}
+
+// Endpoint returns the last resolved diagnostics endpoint of the peer or an
+// empty string if it is not known.
+func (s *State) Endpoint(chainAddress string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if peer, ok := s.peers[chainAddress]; ok {
+		return peer.Endpoint
+	}
+	return ""
+}
+
+// PortOrder returns the ports of the range in the order they should be
+// scanned: ports with most endpoints found first, the rest in ascending
+// order.
+func (s *State) PortOrder(start, end int) []int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ports := make([]int, 0, end-start+1)
+	for port := start; port <= end; port++ {
+		ports = append(ports, port)
+	}
+	sort.SliceStable(ports, func(i, j int) bool {
+		return s.ports[ports[i]] > s.ports[ports[j]]
+	})
+
+	return ports
+}
+
+// Prune removes sources not called and peers not seen since the time.
+func (s *State) Prune(before time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for address, source := range s.sources {
+		if source.LastAttempt.Before(before) {
+			delete(s.sources, address)
+		}
+	}
+	for chainAddress, peer := range s.peers {
+		if peer.LastSeen.Before(before) {
+			delete(s.peers, chainAddress)
+		}
+	}
+}
+
+// Snapshot captures the state. Entries are sorted, so snapshots of the same
+// state are identical.
+func (s *State) Snapshot(at time.Time) *Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	snapshot := &Snapshot{
+		Version:   Version,
+		CreatedAt: at,
+		Sources:   make([]Source, 0, len(s.sources)),
+		Peers:     make([]Peer, 0, len(s.peers)),
+		Ports:     make([]PortStat, 0, len(s.ports)),
+	}
+	for _, source := range s.sources {
+		snapshot.Sources = append(snapshot.Sources, *source)
+	}
+	for _, peer := range s.peers {
+		peer := *peer
+		peer.NetworkAddresses = slices.Clone(peer.NetworkAddresses)
+		snapshot.Peers = append(snapshot.Peers, peer)
+	}
+	for port, found := range s.ports {
+		snapshot.Ports = append(snapshot.Ports, PortStat{Port: port, Found: found})
+	}
+
+	sort.Slice(snapshot.Sources, func(i, j int) bool {
+		return snapshot.Sources[i].Address < snapshot.Sources[j].Address
+	})
+	sort.Slice(snapshot.Peers, func(i, j int) bool {
+		return snapshot.Peers[i].ChainAddress < snapshot.Peers[j].ChainAddress
+	})
+	sort.Slice(snapshot.Ports, func(i, j int) bool {
+		return snapshot.Ports[i].Port < snapshot.Ports[j].Port
+	})
+
+	return snapshot
+}
+
+// Restore replaces the state with the snapshot.
+func (s *State) Restore(snapshot *Snapshot) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sources = make(map[string]*Source, len(snapshot.Sources))
+	for _, source := range snapshot.Sources {
+		source := source
+		s.sources[source.Address] = &source
+	}
+	s.peers = make(map[string]*Peer, len(snapshot.Peers))
+	for _, peer := range snapshot.Peers {
+		peer := peer
+		peer.NetworkAddresses = slices.Clone(peer.NetworkAddresses)
+		s.peers[peer.ChainAddress] = &peer
+	}
+	s.ports = make(map[int]int, len(snapshot.Ports))
+	for _, stat := range snapshot.Ports {
+		s.ports[stat.Port] = stat.Found
+	}
+}
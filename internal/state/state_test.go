@@ -0,0 +1,122 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	start := time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)
+	round := func(i int) time.Time { return start.Add(time.Duration(i) * time.Hour) }
+
+	state := New()
+
+	peerA := Peer{
+		ChainAddress:     "0xA",
+		NetworkID:        "16Uiu2A",
+		NetworkAddresses: []string{"10.0.0.1"},
+		NetworkPort:      3919,
+		Endpoint:         "10.0.0.1:9601",
+		Version:          "v2.0.0",
+	}
+	peerB := Peer{
+		ChainAddress:     "0xB",
+		NetworkID:        "16Uiu2B",
+		NetworkAddresses: []string{"10.0.0.2"},
+		NetworkPort:      3919,
+	}
+
+	state.RecordSource("bootstrap:9601", "0xS", 2, true, round(0))
+	state.RecordPeer(peerA, true, round(0))
+	state.RecordPeer(peerB, false, round(0))
+	state.RecordPortFound(9601)
+
+	state.RecordSource("bootstrap:9601", "", 0, false, round(1))
+	state.RecordPeer(Peer{ChainAddress: "0xA", NetworkID: "16Uiu2A"}, false, round(1))
+
+	t.Run("endpoint", func(t *testing.T) {
+		if endpoint := state.Endpoint("0xA"); endpoint != "10.0.0.1:9601" {
+			t.Errorf("unexpected endpoint of resolved peer: %s", endpoint)
+		}
+		if endpoint := state.Endpoint("0xB"); endpoint != "" {
+			t.Errorf("unexpected endpoint of unresolved peer: %s", endpoint)
+		}
+		if endpoint := state.Endpoint("0xC"); endpoint != "" {
+			t.Errorf("unexpected endpoint of unknown peer: %s", endpoint)
+		}
+	})
+
+	t.Run("port order", func(t *testing.T) {
+		state.RecordPortFound(9603)
+		state.RecordPortFound(9603)
+
+		expected := []int{9603, 9601, 9600, 9602, 9604}
+		if ports := state.PortOrder(9600, 9604); !reflect.DeepEqual(ports, expected) {
+			t.Errorf("unexpected port order\nexpected: %v\nactual:   %v", expected, ports)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		snapshot := state.Snapshot(round(2))
+
+		expected := &Snapshot{
+			Version:   Version,
+			CreatedAt: round(2),
+			Sources: []Source{
+				{
+					Address:      "bootstrap:9601",
+					ChainAddress: "0xS",
+					Peers:        2,
+					LastAttempt:  round(1),
+					LastSuccess:  round(0),
+					Failures:     1,
+				},
+			},
+			Peers: []Peer{
+				{
+					ChainAddress: "0xA",
+					NetworkID:    "16Uiu2A",
+					Endpoint:     "10.0.0.1:9601",
+					Version:      "v2.0.0",
+					LastSeen:     round(1),
+					LastResolved: round(0),
+					Failures:     1,
+				},
+				{
+					ChainAddress:     "0xB",
+					NetworkID:        "16Uiu2B",
+					NetworkAddresses: []string{"10.0.0.2"},
+					NetworkPort:      3919,
+					LastSeen:         round(0),
+					Failures:         1,
+				},
+			},
+			Ports: []PortStat{
+				{Port: 9601, Found: 1},
+				{Port: 9603, Found: 2},
+			},
+		}
+		if !reflect.DeepEqual(snapshot, expected) {
+			t.Errorf("unexpected snapshot\nexpected: %+v\nactual:   %+v", expected, snapshot)
+		}
+
+		restored := New()
+		restored.Restore(snapshot)
+		if actual := restored.Snapshot(round(2)); !reflect.DeepEqual(actual, snapshot) {
+			t.Errorf("unexpected restored snapshot\nexpected: %+v\nactual:   %+v", snapshot, actual)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		state.Prune(round(1))
+
+		snapshot := state.Snapshot(round(2))
+		if len(snapshot.Sources) != 1 {
+			t.Errorf("unexpected number of sources: %d", len(snapshot.Sources))
+		}
+		if len(snapshot.Peers) != 1 || snapshot.Peers[0].ChainAddress != "0xA" {
+			t.Errorf("unexpected peers: %+v", snapshot.Peers)
+		}
+	})
+}
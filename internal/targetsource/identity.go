@@ -0,0 +1,120 @@
+// Package targetsource derives sources identifying target groups of peers and
+// tracks the sources sent to the adapter, so groups that disappear are
+// cleared.
+package targetsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// Strategies of deriving sources of peer target groups.
+const (
+	// ChainAddress identifies groups by the peers' chain addresses.
+	ChainAddress = "chain_address"
+	// Endpoint identifies groups by the targets' addresses.
+	Endpoint = "endpoint"
+	// NetworkID identifies groups by the peers' network IDs.
+	NetworkID = "network_id"
+	// Labels identifies groups by a hash of the chosen target labels.
+	Labels = "labels"
+)
+
+// Strategies lists the supported strategies.
+var Strategies = []string{ChainAddress, Endpoint, NetworkID, Labels}
+
+// Peer holds the data a source of the peer's target group is derived from.
+type Peer struct {
+	ChainAddress string
+	NetworkID    string
+	// Endpoint is the target's address; empty if the peer is unresolved.
+	Endpoint string
+	// Labels are the target's labels after relabeling.
+	Labels model.LabelSet
+	// Kind is the kind of the endpoint scraped by the target, if the peer has
+	// a target for each of its endpoints.
+	Kind string
+}
+
+// Identity derives sources of peer target groups. Sources depend only on the
+// peers' data, so they stay the same across restarts of the discovery.
+type Identity struct {
+	strategy string
+	labels   model.LabelNames
+}
+
+// New creates an identity of the strategy. Labels are the names of the
+// target labels hashed by the labels strategy and must be empty for the
+// other ones.
+func New(strategy string, labels []string) (*Identity, error) {
+	identity := &Identity{strategy: strategy}
+
+	switch strategy {
+	case ChainAddress, Endpoint, NetworkID:
+		if len(labels) > 0 {
+			return nil, fmt.Errorf("labels are supported only by the %s strategy", Labels)
+		}
+	case Labels:
+		if len(labels) == 0 {
+			return nil, fmt.Errorf("labels are required by the %s strategy", Labels)
+		}
+		for _, label := range labels {
+			if !model.LabelName(label).IsValid() {
+				return nil, fmt.Errorf("invalid label name: %q", label)
+			}
+			identity.labels = append(identity.labels, model.LabelName(label))
+		}
+		sort.Sort(identity.labels)
+	default:
+		return nil, fmt.Errorf("unknown strategy: %s", strategy)
+	}
+
+	return identity, nil
+}
+
+// Source returns the source of the peer's target group. Sources other than
+// chain addresses are prefixed with the strategy, so they never collide with
+// sources of groups identified differently, and suffixed with the endpoint
+// kind if set. The chain address is used if the data required by the strategy
+// is missing, like the endpoint of an unresolved peer.
+func (i *Identity) Source(peer Peer) string {
+	source := peer.ChainAddress
+
+	switch i.strategy {
+	case Endpoint:
+		if peer.Endpoint != "" {
+			source = Endpoint + "/" + peer.Endpoint
+		}
+	case NetworkID:
+		if peer.NetworkID != "" {
+			source = NetworkID + "/" + peer.NetworkID
+		}
+	case Labels:
+		source = Labels + "/" + hashLabels(peer.Labels, i.labels)
+	}
+
+	if peer.Kind != "" {
+		source += "/" + peer.Kind
+	}
+
+	return source
+}
+
+// hashLabels returns a hash of the named labels' values. The hash doesn't
+// depend on the order of the names and a missing label hashes as an empty
+// one.
+func hashLabels(labels model.LabelSet, names model.LabelNames) string {
+	var b strings.Builder
+	for _, name := range names {
+		// Quoting keeps the encoding unambiguous for any values.
+		fmt.Fprintf(&b, "%s=%q\n", name, labels[name])
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
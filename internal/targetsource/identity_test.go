@@ -0,0 +1,161 @@
+package targetsource
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+var testPeer = Peer{
+	ChainAddress: "0xA",
+	NetworkID:    "16Uiu2HAmA",
+	Endpoint:     "peer-a.example:9601",
+	Labels: model.LabelSet{
+		"__address__":          "peer-a.example:9601",
+		"__meta_chain_address": "0xA",
+		"__meta_network_id":    "16Uiu2HAmA",
+		"__meta_keep_version":  "v2.0.0",
+	},
+}
+
+func TestSource(t *testing.T) {
+	unresolved := testPeer
+	unresolved.Endpoint = ""
+
+	unknownNetworkID := testPeer
+	unknownNetworkID.NetworkID = ""
+
+	metricsEndpoint := testPeer
+	metricsEndpoint.Kind = "metrics"
+
+	tests := map[string]struct {
+		strategy string
+		labels   []string
+		peer     Peer
+		expected string
+	}{
+		"chain address": {
+			strategy: ChainAddress,
+			peer:     testPeer,
+			expected: "0xA",
+		},
+		"endpoint": {
+			strategy: Endpoint,
+			peer:     testPeer,
+			expected: "endpoint/peer-a.example:9601",
+		},
+		"endpoint of unresolved peer": {
+			strategy: Endpoint,
+			peer:     unresolved,
+			expected: "0xA",
+		},
+		"network ID": {
+			strategy: NetworkID,
+			peer:     testPeer,
+			expected: "network_id/16Uiu2HAmA",
+		},
+		"unknown network ID": {
+			strategy: NetworkID,
+			peer:     unknownNetworkID,
+			expected: "0xA",
+		},
+		"chain address of endpoint kind": {
+			strategy: ChainAddress,
+			peer:     metricsEndpoint,
+			expected: "0xA/metrics",
+		},
+		"endpoint of endpoint kind": {
+			strategy: Endpoint,
+			peer:     metricsEndpoint,
+			expected: "endpoint/peer-a.example:9601/metrics",
+		},
+		"labels": {
+			strategy: Labels,
+			labels:   []string{"__meta_chain_address", "__meta_network_id"},
+			peer:     testPeer,
+			expected: "labels/8378edf5ebf5e9f4",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			identity, err := New(test.strategy, test.labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if source := identity.Source(test.peer); source != test.expected {
+				t.Errorf("unexpected source\nexpected: %s\nactual:   %s", test.expected, source)
+			}
+		})
+	}
+}
+
+// The hash must not depend on anything but the chosen labels, so groups keep
+// their sources across restarts and upgrades of the discovery.
+func TestSourceLabelsStable(t *testing.T) {
+	identity, err := New(Labels, []string{"__meta_chain_address", "__meta_network_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := identity.Source(testPeer)
+
+	// Labels not chosen, like the address and the version, don't affect the
+	// source, so a peer moving ports keeps its group.
+	moved := testPeer
+	moved.Endpoint = "peer-a.example:9602"
+	moved.Labels = testPeer.Labels.Clone()
+	moved.Labels["__address__"] = "peer-a.example:9602"
+	moved.Labels["__meta_keep_version"] = "v2.1.0"
+	if movedSource := identity.Source(moved); movedSource != source {
+		t.Errorf("source changed with labels not chosen: %s != %s", movedSource, source)
+	}
+
+	// Order of the chosen labels doesn't matter.
+	reordered, err := New(Labels, []string{"__meta_network_id", "__meta_chain_address"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reorderedSource := reordered.Source(testPeer); reorderedSource != source {
+		t.Errorf("source changed with order of labels: %s != %s", reorderedSource, source)
+	}
+
+	// Changing a chosen label changes the source.
+	other := testPeer
+	other.Labels = testPeer.Labels.Clone()
+	other.Labels["__meta_chain_address"] = "0xB"
+	if otherSource := identity.Source(other); otherSource == source {
+		t.Errorf("source didn't change with a chosen label: %s", otherSource)
+	}
+}
+
+func TestHashLabelsUnambiguous(t *testing.T) {
+	names := model.LabelNames{"a", "b"}
+
+	first := hashLabels(model.LabelSet{"a": "x\nb=", "b": ""}, names)
+	second := hashLabels(model.LabelSet{"a": "x", "b": "\nb="}, names)
+	if first == second {
+		t.Errorf("different labels hashed equally: %s", first)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := map[string]struct {
+		strategy string
+		labels   []string
+	}{
+		"unknown strategy":      {strategy: "ip"},
+		"labels without labels": {strategy: Labels},
+		"invalid label":         {strategy: Labels, labels: []string{"geo-country"}},
+		"unexpected labels":     {strategy: Endpoint, labels: []string{"__meta_chain_address"}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := New(test.strategy, test.labels); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package targetsource
+
+import (
+	"sort"
+
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// Claim assigns unique sources to the peers' target groups in a complete
+// update. Sources maps chain addresses of the peers to the sources of their
+// groups derived by the identity. If peers share a source, the peer with the
+// lowest chain address keeps it and the others get it suffixed with their
+// chain addresses, so the assignment doesn't depend on the order the peers
+// are discovered in.
+func Claim(sources map[string][]string) map[string][]string {
+	chainAddresses := make([]string, 0, len(sources))
+	for chainAddress := range sources {
+		chainAddresses = append(chainAddresses, chainAddress)
+	}
+	sort.Strings(chainAddresses)
+
+	claimed := make(map[string]bool, len(sources))
+	assigned := make(map[string][]string, len(sources))
+	for _, chainAddress := range chainAddresses {
+		peerSources := make([]string, 0, len(sources[chainAddress]))
+		for _, source := range sources[chainAddress] {
+			if claimed[source] {
+				source = suffixed(source, chainAddress)
+			}
+
+			claimed[source] = true
+			peerSources = append(peerSources, source)
+		}
+		assigned[chainAddress] = peerSources
+	}
+
+	return assigned
+}
+
+func suffixed(source, chainAddress string) string {
+	return source + "#" + chainAddress
+}
+
+// Tracker keeps the sources of target groups sent to the adapter, so groups
+// missing in a later update are cleared. It's not safe for concurrent use.
+type Tracker struct {
+	// Sources of the groups sent to the adapter and not cleared since.
+	sent map[string]bool
+	// Chain addresses of the peers by sources of their groups.
+	owners map[string]string
+}
+
+// NewTracker creates a tracker with no sources sent.
+func NewTracker() *Tracker {
+	return &Tracker{
+		sent:   make(map[string]bool),
+		owners: make(map[string]string),
+	}
+}
+
+// Complete returns the groups of a complete update with an empty group
+// appended for each source sent before but missing in the update, so the
+// adapter removes its targets. Owners maps sources of the peers' groups to
+// the peers' chain addresses; groups not owned by peers, like static ones,
+// are omitted.
+func (t *Tracker) Complete(
+	tgs []*targetgroup.Group,
+	owners map[string]string,
+) []*targetgroup.Group {
+	sent := make(map[string]bool, len(tgs))
+	for _, tg := range tgs {
+		sent[tg.Source] = true
+	}
+
+	tgs = append(tgs, tombstones(t.sent, sent)...)
+
+	t.sent = sent
+	t.owners = make(map[string]string, len(owners))
+	for source, chainAddress := range owners {
+		t.owners[source] = chainAddress
+	}
+
+	return tgs
+}
+
+// Partial assigns sources to the peer's groups updated between complete
+// updates. Sources are suffixed as by Claim if they're owned by other peers.
+// It returns the assigned sources and an empty group for each of the peer's
+// groups sent before but missing in the update, which must be sent along.
+func (t *Tracker) Partial(
+	chainAddress string,
+	sources []string,
+) (assigned []string, cleared []*targetgroup.Group) {
+	assigned = make([]string, 0, len(sources))
+	current := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		if owner, ok := t.owners[source]; ok && owner != chainAddress {
+			source = suffixed(source, chainAddress)
+		}
+
+		assigned = append(assigned, source)
+		current[source] = true
+	}
+
+	previous := make(map[string]bool)
+	for source, owner := range t.owners {
+		if owner == chainAddress && !current[source] {
+			previous[source] = true
+			delete(t.owners, source)
+			delete(t.sent, source)
+		}
+	}
+	cleared = tombstones(previous, current)
+
+	for _, source := range assigned {
+		t.owners[source] = chainAddress
+		t.sent[source] = true
+	}
+
+	return assigned, cleared
+}
+
+// tombstones returns an empty group for each source sent before but missing
+// in the current ones, in a stable order.
+func tombstones(sent, current map[string]bool) []*targetgroup.Group {
+	stale := make([]string, 0)
+	for source := range sent {
+		if !current[source] {
+			stale = append(stale, source)
+		}
+	}
+	sort.Strings(stale)
+
+	tgs := make([]*targetgroup.Group, 0, len(stale))
+	for _, source := range stale {
+		tgs = append(tgs, &targetgroup.Group{Source: source})
+	}
+	return tgs
+}
@@ -0,0 +1,171 @@
+package targetsource
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestClaim(t *testing.T) {
+	sources := map[string][]string{
+		"0xC": {"endpoint/proxy.example:9601/metrics", "endpoint/proxy.example:9601/diagnostics"},
+		"0xA": {"endpoint/proxy.example:9601/metrics"},
+		"0xB": {"endpoint/peer-b.example:9601/metrics"},
+	}
+
+	expected := map[string][]string{
+		"0xA": {"endpoint/proxy.example:9601/metrics"},
+		"0xB": {"endpoint/peer-b.example:9601/metrics"},
+		"0xC": {"endpoint/proxy.example:9601/metrics#0xC", "endpoint/proxy.example:9601/diagnostics"},
+	}
+
+	// The assignment must not depend on the map's iteration order.
+	for i := 0; i < 10; i++ {
+		if claimed := Claim(sources); !reflect.DeepEqual(claimed, expected) {
+			t.Fatalf("unexpected sources\nexpected: %v\nactual:   %v", expected, claimed)
+		}
+	}
+}
+
+func TestTrackerComplete(t *testing.T) {
+	tracker := NewTracker()
+
+	tgs := tracker.Complete(groups("0xA", "0xB", "static/0"), map[string]string{
+		"0xA": "0xA",
+		"0xB": "0xB",
+	})
+	assertGroups(t, tgs, []string{"0xA", "0xB", "static/0"}, nil)
+
+	// Groups missing in the update are cleared.
+	tgs = tracker.Complete(groups("0xA"), map[string]string{"0xA": "0xA"})
+	assertGroups(t, tgs, []string{"0xA"}, []string{"0xB", "static/0"})
+
+	// Cleared groups are not cleared again.
+	tgs = tracker.Complete(groups("0xA"), map[string]string{"0xA": "0xA"})
+	assertGroups(t, tgs, []string{"0xA"}, nil)
+
+	// A peer's group changing its source is cleared under the old one.
+	tgs = tracker.Complete(groups("endpoint/peer-a.example:9601"), map[string]string{
+		"endpoint/peer-a.example:9601": "0xA",
+	})
+	assertGroups(t, tgs, []string{"endpoint/peer-a.example:9601"}, []string{"0xA"})
+}
+
+func TestTrackerPartial(t *testing.T) {
+	tracker := NewTracker()
+
+	// Unresolved peer is identified by its chain address.
+	tracker.Complete(groups("0xA", "endpoint/peer-b.example:9601"), map[string]string{
+		"0xA":                          "0xA",
+		"endpoint/peer-b.example:9601": "0xB",
+	})
+
+	// Once resolved, its group sent under the chain address is cleared.
+	assertPartial(
+		t,
+		tracker,
+		"0xA",
+		[]string{"endpoint/peer-a.example:9601/metrics", "endpoint/peer-a.example:9601/diagnostics"},
+		[]string{"endpoint/peer-a.example:9601/metrics", "endpoint/peer-a.example:9601/diagnostics"},
+		[]string{"0xA"},
+	)
+
+	// A group of an endpoint that stopped responding is cleared.
+	assertPartial(
+		t,
+		tracker,
+		"0xA",
+		[]string{"endpoint/peer-a.example:9601/metrics"},
+		[]string{"endpoint/peer-a.example:9601/metrics"},
+		[]string{"endpoint/peer-a.example:9601/diagnostics"},
+	)
+
+	// Updating the groups again leaves nothing to clear.
+	assertPartial(
+		t,
+		tracker,
+		"0xA",
+		[]string{"endpoint/peer-a.example:9601/metrics"},
+		[]string{"endpoint/peer-a.example:9601/metrics"},
+		nil,
+	)
+
+	// A source owned by another peer is suffixed.
+	assertPartial(
+		t,
+		tracker,
+		"0xC",
+		[]string{"endpoint/peer-b.example:9601"},
+		[]string{"endpoint/peer-b.example:9601#0xC"},
+		nil,
+	)
+
+	// The next complete update clears partially updated groups missing in
+	// it, but not the groups cleared already.
+	tgs := tracker.Complete(groups("endpoint/peer-b.example:9601"), map[string]string{
+		"endpoint/peer-b.example:9601": "0xB",
+	})
+	assertGroups(
+		t,
+		tgs,
+		[]string{"endpoint/peer-b.example:9601"},
+		[]string{"endpoint/peer-a.example:9601/metrics", "endpoint/peer-b.example:9601#0xC"},
+	)
+}
+
+func assertPartial(
+	t *testing.T,
+	tracker *Tracker,
+	chainAddress string,
+	sources []string,
+	expectedAssigned []string,
+	expectedCleared []string,
+) {
+	t.Helper()
+
+	assigned, cleared := tracker.Partial(chainAddress, sources)
+	if !reflect.DeepEqual(assigned, expectedAssigned) {
+		t.Errorf("unexpected assigned sources\nexpected: %v\nactual:   %v", expectedAssigned, assigned)
+	}
+	assertGroups(t, cleared, []string{}, expectedCleared)
+}
+
+func groups(sources ...string) []*targetgroup.Group {
+	tgs := make([]*targetgroup.Group, 0, len(sources))
+	for _, source := range sources {
+		tgs = append(tgs, &targetgroup.Group{
+			Source:  source,
+			Targets: []model.LabelSet{{model.AddressLabel: "peer.example:9601"}},
+		})
+	}
+	return tgs
+}
+
+func assertGroups(t *testing.T, tgs []*targetgroup.Group, expectedSent, expectedCleared []string) {
+	t.Helper()
+
+	sent := make([]string, 0)
+	cleared := make([]string, 0)
+	for _, tg := range tgs {
+		if len(tg.Targets) == 0 {
+			cleared = append(cleared, tg.Source)
+		} else {
+			sent = append(sent, tg.Source)
+		}
+	}
+	sort.Strings(sent)
+	sort.Strings(cleared)
+
+	if expectedCleared == nil {
+		expectedCleared = []string{}
+	}
+	if !reflect.DeepEqual(sent, expectedSent) {
+		t.Errorf("unexpected sent groups\nexpected: %v\nactual:   %v", expectedSent, sent)
+	}
+	if !reflect.DeepEqual(cleared, expectedCleared) {
+		t.Errorf("unexpected cleared groups\nexpected: %v\nactual:   %v", expectedCleared, cleared)
+	}
+}
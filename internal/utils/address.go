@@ -1,25 +1,200 @@
 package utils
 
 import (
+	"fmt"
 	"net"
+	"regexp"
 	"sort"
+	"strings"
 )
 
-// SortAddresses sorts slice of addresses.
+// SortAddresses sorts slice of addresses. Hostnames go first, followed by
+// IPv4 and then IPv6 addresses.
 func SortAddresses(addresses []string) []string {
 	nonIps := make([]string, 0)
-	ips := make([]string, 0)
+	ipv4s := make([]string, 0)
+	ipv6s := make([]string, 0)
 
 	for _, address := range addresses {
 		if ip := net.ParseIP(address); ip != nil {
-			ips = append(ips, address)
+			if ip.To4() != nil {
+				ipv4s = append(ipv4s, address)
+			} else {
+				ipv6s = append(ipv6s, address)
+			}
 			continue
 		}
 		nonIps = append(nonIps, address)
 	}
 
 	sort.Strings(nonIps)
-	sort.Sort(sort.Reverse(sort.StringSlice(ips)))
+	sort.Sort(sort.Reverse(sort.StringSlice(ipv4s)))
+	sort.Sort(sort.Reverse(sort.StringSlice(ipv6s)))
 
-	return append(nonIps, ips...)
+	return append(append(nonIps, ipv4s...), ipv6s...)
+}
+
+// MatchesAddress reports whether the network address matches the pattern: a
+// CIDR block containing the address, an IP address equal to it in any
+// notation, like ::1 and 0:0:0:0:0:0:0:1, or a hostname equal to it ignoring
+// case.
+func MatchesAddress(address, pattern string) bool {
+	if ip := net.ParseIP(address); ip != nil {
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			return network.Contains(ip)
+		}
+		if patternIP := net.ParseIP(pattern); patternIP != nil {
+			return patternIP.Equal(ip)
+		}
+	}
+
+	return strings.EqualFold(address, pattern)
+}
+
+// AddressInfo describes a network address of a peer.
+type AddressInfo struct {
+	Address string
+	// Sources is the number of diagnostics sources reporting the address.
+	Sources int
+}
+
+// AddressRule compares two addresses. It returns a negative number if a is
+// preferred over b, a positive number if b is preferred over a and zero if
+// the rule has no preference.
+type AddressRule func(a, b AddressInfo) int
+
+// preferIf creates a rule preferring addresses satisfying the predicate.
+func preferIf(predicate func(address string) bool) AddressRule {
+	return func(a, b AddressInfo) int {
+		aPreferred, bPreferred := predicate(a.Address), predicate(b.Address)
+		switch {
+		case aPreferred && !bPreferred:
+			return -1
+		case !aPreferred && bPreferred:
+			return 1
+		default:
+			return 0
+		}
+	}
+}
+
+// PreferPublicIPv4 prefers publicly routable IPv4 addresses.
+func PreferPublicIPv4() AddressRule {
+	return preferIf(func(address string) bool {
+		ip := net.ParseIP(address)
+		return ip != nil && ip.To4() != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+	})
+}
+
+// PreferIPv4 prefers IPv4 addresses.
+func PreferIPv4() AddressRule {
+	return preferIf(func(address string) bool {
+		ip := net.ParseIP(address)
+		return ip != nil && ip.To4() != nil
+	})
+}
+
+// PreferIPv6 prefers IPv6 addresses.
+func PreferIPv6() AddressRule {
+	return preferIf(func(address string) bool {
+		ip := net.ParseIP(address)
+		return ip != nil && ip.To4() == nil
+	})
+}
+
+// PreferHostnames prefers DNS names over IP addresses.
+func PreferHostnames() AddressRule {
+	return preferIf(func(address string) bool {
+		return net.ParseIP(address) == nil
+	})
+}
+
+// PreferMatching prefers addresses fully matching the regular expression.
+func PreferMatching(re *regexp.Regexp) AddressRule {
+	return preferIf(re.MatchString)
+}
+
+// PreferMostSources prefers addresses reported by more diagnostics sources.
+func PreferMostSources() AddressRule {
+	return func(a, b AddressInfo) int {
+		return b.Sources - a.Sources
+	}
+}
+
+// Names of the address preference rules.
+const (
+	AddressRulePublicIPv4  = "public-ipv4"
+	AddressRuleIPv4        = "ipv4"
+	AddressRuleIPv6        = "ipv6"
+	AddressRuleHostname    = "hostname"
+	AddressRuleMostSources = "most-sources"
+	// AddressRuleRegex prefixes the pattern of the rule preferring addresses
+	// matching it.
+	AddressRuleRegex = "regex:"
+)
+
+// AddressRuleNames lists the supported address preference rules, with the
+// regex rule's pattern as a placeholder.
+var AddressRuleNames = []string{
+	AddressRulePublicIPv4,
+	AddressRuleIPv4,
+	AddressRuleIPv6,
+	AddressRuleHostname,
+	AddressRuleMostSources,
+	AddressRuleRegex + "<pattern>",
+}
+
+// ParseAddressRule parses an address preference rule, one of
+// AddressRuleNames.
+func ParseAddressRule(rule string) (AddressRule, error) {
+	if strings.HasPrefix(rule, AddressRuleRegex) {
+		pattern := strings.TrimPrefix(rule, AddressRuleRegex)
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", pattern, err)
+		}
+		return PreferMatching(re), nil
+	}
+
+	switch rule {
+	case AddressRulePublicIPv4:
+		return PreferPublicIPv4(), nil
+	case AddressRuleIPv4:
+		return PreferIPv4(), nil
+	case AddressRuleIPv6:
+		return PreferIPv6(), nil
+	case AddressRuleHostname:
+		return PreferHostnames(), nil
+	case AddressRuleMostSources:
+		return PreferMostSources(), nil
+	default:
+		return nil, fmt.Errorf("unknown address preference rule: %s", rule)
+	}
+}
+
+// SortAddressesByPreference sorts addresses by the rules, applied in order
+// until one of them expresses a preference. Addresses the rules don't
+// distinguish keep the order of SortAddresses.
+func SortAddressesByPreference(addresses []AddressInfo, rules []AddressRule) []string {
+	sources := make(map[string]int, len(addresses))
+	plain := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		sources[address.Address] = address.Sources
+		plain = append(plain, address.Address)
+	}
+
+	sorted := SortAddresses(plain)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a := AddressInfo{Address: sorted[i], Sources: sources[sorted[i]]}
+		b := AddressInfo{Address: sorted[j], Sources: sources[sorted[j]]}
+		for _, rule := range rules {
+			if result := rule(a, b); result != 0 {
+				return result < 0
+			}
+		}
+		return false
+	})
+
+	return sorted
 }
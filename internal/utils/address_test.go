@@ -9,7 +9,9 @@ import (
 func TestSortAddresses(t *testing.T) {
 	addresses := []string{
 		"127.0.0.1",
+		"2604:1380:2000:7a00::1",
 		"bootstrap-1.test.keep.network",
+		"::1",
 		"10.102.2.4",
 		"34.141.9.57",
 		"bootstrap-2.test.keep.network",
@@ -22,6 +24,8 @@ func TestSortAddresses(t *testing.T) {
 		"34.141.9.57",
 		"127.0.0.1",
 		"10.102.2.4",
+		"::1",
+		"2604:1380:2000:7a00::1",
 	}
 
 	sortedAddresses := SortAddresses(addresses)
@@ -34,3 +38,146 @@ func TestSortAddresses(t *testing.T) {
 		)
 	}
 }
+
+func TestMatchesAddress(t *testing.T) {
+	var tests = map[string]struct {
+		address  string
+		pattern  string
+		expected bool
+	}{
+		"equal ipv4":              {address: "192.0.2.1", pattern: "192.0.2.1", expected: true},
+		"other ipv4":              {address: "192.0.2.1", pattern: "192.0.2.2", expected: false},
+		"ipv6 in full notation":   {address: "::1", pattern: "0:0:0:0:0:0:0:1", expected: true},
+		"ipv6 in compressed form": {address: "2001:db8:0:0::1", pattern: "2001:DB8::1", expected: true},
+		"ipv4-mapped ipv6":        {address: "::ffff:192.0.2.1", pattern: "192.0.2.1", expected: true},
+		"ipv4 in cidr":            {address: "192.0.2.1", pattern: "192.0.2.0/24", expected: true},
+		"ipv4 out of cidr":        {address: "198.51.100.1", pattern: "192.0.2.0/24", expected: false},
+		"ipv6 in cidr":            {address: "2001:db8::1", pattern: "2001:db8::/32", expected: true},
+		"hostname":                {address: "bootstrap-1.test.keep.network", pattern: "bootstrap-1.test.keep.network", expected: true},
+		"hostname ignoring case":  {address: "Bootstrap-1.test.keep.network", pattern: "bootstrap-1.TEST.keep.network", expected: true},
+		"hostname against cidr":   {address: "bootstrap-1.test.keep.network", pattern: "192.0.2.0/24", expected: false},
+		"ip against hostname":     {address: "192.0.2.1", pattern: "bootstrap-1.test.keep.network", expected: false},
+		"empty pattern":           {address: "192.0.2.1", pattern: "", expected: false},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			if matches := MatchesAddress(test.address, test.pattern); matches != test.expected {
+				t.Errorf(
+					"unexpected match of %s against %s\nexpected: %v\nactual:   %v",
+					test.address,
+					test.pattern,
+					test.expected,
+					matches,
+				)
+			}
+		})
+	}
+}
+
+func TestSortAddressesByPreference(t *testing.T) {
+	addresses := []AddressInfo{
+		{Address: "bootstrap-1.test.keep.network", Sources: 1},
+		{Address: "10.102.2.4", Sources: 1},
+		{Address: "34.141.9.57", Sources: 3},
+		{Address: "2001:db8::1", Sources: 2},
+		{Address: "0-node.test.keep.network", Sources: 2},
+	}
+
+	var tests = map[string]struct {
+		rules             []string
+		expectedAddresses []string
+	}{
+		"no rules": {
+			rules: []string{},
+			expectedAddresses: []string{
+				"0-node.test.keep.network",
+				"bootstrap-1.test.keep.network",
+				"34.141.9.57",
+				"10.102.2.4",
+				"2001:db8::1",
+			},
+		},
+		"public ipv4": {
+			rules: []string{"public-ipv4"},
+			expectedAddresses: []string{
+				"34.141.9.57",
+				"0-node.test.keep.network",
+				"bootstrap-1.test.keep.network",
+				"10.102.2.4",
+				"2001:db8::1",
+			},
+		},
+		"ipv4 then hostname": {
+			rules: []string{"ipv4", "hostname"},
+			expectedAddresses: []string{
+				"34.141.9.57",
+				"10.102.2.4",
+				"0-node.test.keep.network",
+				"bootstrap-1.test.keep.network",
+				"2001:db8::1",
+			},
+		},
+		"ipv6 then public ipv4": {
+			rules: []string{"ipv6", "public-ipv4"},
+			expectedAddresses: []string{
+				"2001:db8::1",
+				"34.141.9.57",
+				"0-node.test.keep.network",
+				"bootstrap-1.test.keep.network",
+				"10.102.2.4",
+			},
+		},
+		"regex": {
+			rules: []string{"regex:bootstrap-.*"},
+			expectedAddresses: []string{
+				"bootstrap-1.test.keep.network",
+				"0-node.test.keep.network",
+				"34.141.9.57",
+				"10.102.2.4",
+				"2001:db8::1",
+			},
+		},
+		"most sources then hostname": {
+			rules: []string{"most-sources", "hostname"},
+			expectedAddresses: []string{
+				"34.141.9.57",
+				"0-node.test.keep.network",
+				"2001:db8::1",
+				"bootstrap-1.test.keep.network",
+				"10.102.2.4",
+			},
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			rules := make([]AddressRule, 0, len(test.rules))
+			for _, ruleString := range test.rules {
+				rule, err := ParseAddressRule(ruleString)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rules = append(rules, rule)
+			}
+
+			sortedAddresses := SortAddressesByPreference(addresses, rules)
+
+			if slices.Compare(test.expectedAddresses, sortedAddresses) != 0 {
+				t.Errorf(
+					"invalid addresses\nexpected: %s\nactual:   %s",
+					test.expectedAddresses,
+					sortedAddresses,
+				)
+			}
+		})
+	}
+}
+
+func TestParseAddressRuleInvalid(t *testing.T) {
+	for _, rule := range []string{"unknown", "regex:("} {
+		if _, err := ParseAddressRule(rule); err == nil {
+			t.Errorf("expected error for rule %s", rule)
+		}
+	}
+}
@@ -1,17 +1,24 @@
 package utils
 
 import (
+	"context"
 	"net"
 	"strconv"
 	"time"
 )
 
-func IsPortOpen(protocol, hostname string, port int, scanPortTimeout time.Duration) bool {
-	address := hostname + ":" + strconv.Itoa(port)
-	conn, err := net.DialTimeout(protocol, address, scanPortTimeout)
+// CheckPortOpen tries to connect to the port with the dialer and returns the
+// dial error if the port is not open. The dial is aborted when the context is
+// done.
+func CheckPortOpen(ctx context.Context, dialer ContextDialer, protocol, hostname string, port int, scanPortTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, scanPortTimeout)
+	defer cancel()
+
+	address := net.JoinHostPort(hostname, strconv.Itoa(port))
+	conn, err := dialer.DialContext(ctx, protocol, address)
 	if err != nil {
-		return false
+		return err
 	}
 	defer conn.Close()
-	return true
+	return nil
 }
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckPortOpen(t *testing.T) {
+	var tests = map[string]struct {
+		network string
+		address string
+	}{
+		"ipv4": {network: "tcp4", address: "127.0.0.1:0"},
+		"ipv6": {network: "tcp6", address: "[::1]:0"},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			listener, err := net.Listen(test.network, test.address)
+			if err != nil {
+				t.Skipf("%s is not supported: %v", test.network, err)
+			}
+			defer listener.Close()
+
+			address := listener.Addr().(*net.TCPAddr)
+
+			err = CheckPortOpen(
+				context.Background(),
+				&net.Dialer{},
+				"tcp",
+				address.IP.String(),
+				address.Port,
+				time.Second,
+			)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			listener.Close()
+
+			err = CheckPortOpen(
+				context.Background(),
+				&net.Dialer{},
+				"tcp",
+				address.IP.String(),
+				address.Port,
+				time.Second,
+			)
+			if err == nil {
+				t.Errorf("expected port to be closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ContextDialer dials connections with a context.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// NewProxyDialer creates a dialer tunneling connections through the proxy
+// under the URL. Supported schemes are socks5 and http, where the latter uses
+// the HTTP CONNECT method. Destinations matching any of the no-proxy entries
+// are dialed directly. An entry can be a CIDR, an IP address, a domain name or
+// a domain suffix starting with a dot.
+func NewProxyDialer(proxyURL string, noProxy []string, direct ContextDialer) (ContextDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+
+	var proxyDialer proxy.Dialer
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+
+		proxyDialer, err = proxy.SOCKS5("tcp", u.Host, auth, contextDialerAdapter{direct})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create socks5 dialer: %w", err)
+		}
+	case "http":
+		proxyDialer = &httpConnectDialer{proxyURL: u, forward: direct}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	perHost := proxy.NewPerHost(proxyDialer, contextDialerAdapter{direct})
+	for _, entry := range noProxy {
+		perHost.AddFromString(entry)
+	}
+
+	return perHost, nil
+}
+
+// contextDialerAdapter adapts a ContextDialer to the proxy.Dialer interface.
+type contextDialerAdapter struct {
+	ContextDialer
+}
+
+func (a contextDialerAdapter) Dial(network, address string) (net.Conn, error) {
+	return a.DialContext(context.Background(), network, address)
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  ContextDialer
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.proxyURL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %w", err)
+	}
+
+	// Abort the handshake when the context is done.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.proxyURL.User != nil {
+		password, _ := d.proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString(
+			[]byte(d.proxyURL.User.Username() + ":" + password),
+		)
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send connect request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read connect response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused connection to %s: %s", address, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn reads data the proxy sent right after the CONNECT response
+// before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
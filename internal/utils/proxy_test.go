@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newConnectProxy starts an HTTP proxy supporting the CONNECT method and
+// counting tunneled connections.
+func newConnectProxy(t *testing.T, tunnels *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		atomic.AddInt32(tunnels, 1)
+
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}))
+}
+
+func TestProxyDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("keep"))
+			conn.Close()
+		}
+	}()
+
+	var tunnels int32
+	proxyServer := newConnectProxy(t, &tunnels)
+	defer proxyServer.Close()
+
+	var tests = map[string]struct {
+		noProxy         []string
+		expectedTunnels int32
+	}{
+		"through proxy": {
+			noProxy:         nil,
+			expectedTunnels: 1,
+		},
+		"no proxy cidr": {
+			noProxy:         []string{"127.0.0.0/8"},
+			expectedTunnels: 0,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			atomic.StoreInt32(&tunnels, 0)
+
+			dialer, err := NewProxyDialer(proxyServer.URL, test.noProxy, &net.Dialer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			conn, err := dialer.DialContext(ctx, "tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer conn.Close()
+
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "keep" {
+				t.Errorf("invalid data\nexpected: %s\nactual:   %s", "keep", data)
+			}
+
+			if actualTunnels := atomic.LoadInt32(&tunnels); actualTunnels != test.expectedTunnels {
+				t.Errorf("invalid tunnels number\nexpected: %d\nactual:   %d", test.expectedTunnels, actualTunnels)
+			}
+		})
+	}
+}
+
+func TestProxyDialerUnsupportedScheme(t *testing.T) {
+	if _, err := NewProxyDialer("ftp://proxy:21", nil, &net.Dialer{}); err == nil {
+		t.Errorf("expected error")
+	}
+}
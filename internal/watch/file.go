@@ -0,0 +1,116 @@
+// Package watch reloads configuration files when they change.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// File holds the content of a file loaded with a loader function. The file is
+// reloaded when its modification time or size changes. If reloading fails,
+// the previously loaded content is kept.
+type File[T any] struct {
+	path string
+	load func(path string) (T, error)
+
+	mutex   sync.RWMutex
+	value   T
+	modTime time.Time
+	size    int64
+}
+
+// NewFile loads the file with the loader function. It returns an error if
+// the initial load fails.
+func NewFile[T any](path string, load func(path string) (T, error)) (*File[T], error) {
+	f := &File[T]{path: path, load: load}
+
+	if _, err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Path returns the path of the file.
+func (f *File[T]) Path() string {
+	return f.path
+}
+
+// Get returns the most recently loaded content of the file.
+func (f *File[T]) Get() T {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return f.value
+}
+
+// Reload loads the file if it has changed since it was last loaded. It
+// returns true if the content has been reloaded.
+func (f *File[T]) Reload() (bool, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	f.mutex.RLock()
+	unchanged := info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	value, err := f.load(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.value = value
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return true, nil
+}
+
+// Watch checks the file for changes every interval until the context is done.
+// The onChange function is called after the file has been reloaded.
+func (f *File[T]) Watch(
+	ctx context.Context,
+	interval time.Duration,
+	logger log.Logger,
+	onChange func(),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		reloaded, err := f.Reload()
+		if err != nil {
+			level.Error(logger).Log(
+				"msg", "failed to reload file; keeping previous content",
+				"file", f.path,
+				"err", err,
+			)
+			continue
+		}
+
+		if reloaded {
+			level.Info(logger).Log("msg", "file reloaded", "file", f.path)
+			onChange()
+		}
+	}
+}
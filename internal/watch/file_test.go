@@ -0,0 +1,107 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+func loadText(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(string(content), "invalid") {
+		return "", fmt.Errorf("invalid content")
+	}
+	return string(content), nil
+}
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, path, "first", modTime)
+
+	file, err := NewFile(path, loadText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := file.Get(); value != "first" {
+		t.Errorf("invalid value\nexpected: %s\nactual:   %s", "first", value)
+	}
+
+	reloaded, err := file.Reload()
+	if err != nil || reloaded {
+		t.Errorf("unexpected reload of unchanged file: %v, %v", reloaded, err)
+	}
+
+	writeFile(t, path, "second", modTime.Add(time.Minute))
+	reloaded, err = file.Reload()
+	if err != nil || !reloaded {
+		t.Errorf("expected reload of changed file: %v, %v", reloaded, err)
+	}
+	if value := file.Get(); value != "second" {
+		t.Errorf("invalid value\nexpected: %s\nactual:   %s", "second", value)
+	}
+
+	// Invalid content doesn't replace the previously loaded one.
+	writeFile(t, path, "invalid", modTime.Add(2*time.Minute))
+	if _, err := file.Reload(); err == nil {
+		t.Errorf("expected error")
+	}
+	if value := file.Get(); value != "second" {
+		t.Errorf("invalid value\nexpected: %s\nactual:   %s", "second", value)
+	}
+}
+
+func TestFileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, path, "first", modTime)
+
+	file, err := NewFile(path, loadText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	go file.Watch(ctx, 10*time.Millisecond, log.NewNopLogger(), func() {
+		changed <- struct{}{}
+	})
+
+	writeFile(t, path, "second", modTime.Add(time.Minute))
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not detected")
+	}
+
+	if value := file.Get(); value != "second" {
+		t.Errorf("invalid value\nexpected: %s\nactual:   %s", "second", value)
+	}
+}
+
+func TestNewFileMissing(t *testing.T) {
+	if _, err := NewFile(filepath.Join(t.TempDir(), "missing.txt"), loadText); err == nil {
+		t.Errorf("expected error")
+	}
+}
@@ -18,27 +18,48 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
-	"golang.org/x/exp/slices"
 
 	"github.com/keep-network/keep-core/pkg/clientinfo"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/prometheus/prometheus/discovery/targetgroup"
-	"github.com/prometheus/prometheus/documentation/examples/custom-sd/adapter"
-
+	"github.com/prometheus/prometheus/model/relabel"
+
+	"github.com/keep-network/prometheus-sd/internal/alerting"
+	"github.com/keep-network/prometheus-sd/internal/diagnostics"
+	"github.com/keep-network/prometheus-sd/internal/election"
+	"github.com/keep-network/prometheus-sd/internal/events"
+	"github.com/keep-network/prometheus-sd/internal/geoip"
+	"github.com/keep-network/prometheus-sd/internal/graph"
+	"github.com/keep-network/prometheus-sd/internal/health"
+	"github.com/keep-network/prometheus-sd/internal/identity"
+	"github.com/keep-network/prometheus-sd/internal/overrides"
+	"github.com/keep-network/prometheus-sd/internal/registry"
+	"github.com/keep-network/prometheus-sd/internal/relabeling"
+	"github.com/keep-network/prometheus-sd/internal/state"
+	"github.com/keep-network/prometheus-sd/internal/targetsource"
 	"github.com/keep-network/prometheus-sd/internal/utils"
+	"github.com/keep-network/prometheus-sd/internal/watch"
 )
 
 var (
@@ -49,10 +70,15 @@ var (
 	config = &sdConfig{}
 	logger log.Logger
 
+	runCmd = app.Command("run", "Run the discovery and export targets to the output file.").Default()
+
 	scanPortRangeFlagValue string
 
 	labelChainAddress = model.MetaLabelPrefix + "chain_address"
 	labelNetworkID    = model.MetaLabelPrefix + "network_id"
+	labelVerified     = model.MetaLabelPrefix + "keep_verified"
+	labelVersion      = model.MetaLabelPrefix + "keep_version"
+	labelEndpointKind = model.MetaLabelPrefix + "keep_endpoint_kind"
 )
 
 type sdConfig struct {
@@ -60,13 +86,67 @@ type sdConfig struct {
 	listenAddresses []string
 
 	refreshInterval time.Duration
+	verifyInterval  time.Duration
+	scanInterval    time.Duration
+
+	diagnosticsPortRange   utils.Range
+	scanPortTimeout        time.Duration
+	bannedPeerAddresses    []string
+	allowPrivateAddresses  bool
+	allowLoopbackAddresses bool
+	scanIPv6               bool
+
+	getDiagnosticsTimeout      time.Duration
+	diagnosticsConfigFile      string
+	diagnosticsMaxBodySize     units.Base2Bytes
+	diagnosticsIdleConnTimeout time.Duration
+
+	relabelConfigFile string
+
+	geoipCityDatabase string
+	geoipASNDatabase  string
+
+	overridesFile string
+	registryFile  string
+	watchInterval time.Duration
+
+	addressPreference []string
+	targetAddressType string
+
+	targetPaths          []string
+	sourceIdentity       string
+	sourceIdentityLabels []string
+
+	verifyNetworkID        bool
+	verifyHandshake        bool
+	verifyHandshakeTimeout time.Duration
 
-	diagnosticsPortRange  utils.Range
-	scanPortTimeout       time.Duration
-	bannedPeerAddresses   []string
-	allowPrivateAddresses bool
+	shutdownTimeout time.Duration
 
-	getDiagnosticsTimeout time.Duration
+	historyFile string
+
+	webListenAddress string
+
+	eventsLog            bool
+	eventsWebhookURLs    []string
+	eventsWebhookRetries int
+	eventsWebhookTimeout time.Duration
+
+	alertmanagerURLs              []string
+	alertmanagerTimeout           time.Duration
+	alertmanagerPeerDropThreshold float64
+
+	streamDebounce time.Duration
+
+	snapshotSeed   string
+	stateRetention time.Duration
+
+	electionMethod        string
+	electionPath          string
+	electionResultFile    string
+	electionID            string
+	electionLeaseDuration time.Duration
+	electionRenewInterval time.Duration
 
 	logJson bool
 }
@@ -79,12 +159,109 @@ type peerData struct {
 	NetworkAddresses []string
 	NetworkPort      int
 
+	// Set from the overrides file.
+	FixedEndpoint bool
+	ExtraLabels   model.LabelSet
+
+	// Set from the operators registry.
+	OperatorLabels model.LabelSet
+
+	// Set from the GeoIP databases.
+	GeoLabels model.LabelSet
+
 	// Resolved by the port scanning.
-	ClientInfoEndpoint string
+	ClientInfoEndpoint   string
+	EndpointIP           string
+	Version              string
+	DiagnosticsNetworkID string
+	// Chain addresses of peers connected to the peer reported by its own
+	// diagnostics.
+	ConnectedPeers []string
+
+	// Result of the identity verification; empty if the verification is
+	// disabled or the peer's endpoint is not resolved.
+	Verified string
+
+	// Configured endpoint paths responding at the resolved endpoint.
+	EndpointPaths []endpointPath
 }
 
 type discovery struct {
-	oldSourceList map[string]bool
+	// Derives sources of the peers' target groups and tracks the ones sent
+	// to the adapter.
+	sourceIdentity *targetsource.Identity
+	sources        *targetsource.Tracker
+
+	clients *diagnostics.Clients
+
+	// Overrides and static targets; nil if not configured.
+	overrides *watch.File[*overrides.Config]
+	// GeoIP databases; nil if not configured.
+	geoip *geoip.Databases
+	// Operators registry; nil if not configured.
+	registry *watch.File[*registry.Registry]
+	// Requests a discovery round to be run without waiting for the ticker.
+	rerun chan struct{}
+
+	// Relabeling rules applied to targets before they are exported.
+	relabelConfigs []*relabel.Config
+
+	// Paths exported as separate targets of each peer; empty if the peers
+	// have a single target.
+	endpointPaths []endpointPath
+
+	// Rules ordering the peer's network addresses for scanning.
+	addressRules []utils.AddressRule
+
+	// Verifies peer IDs with a handshake; nil if disabled.
+	verifier *identity.Verifier
+
+	// State carried over between rounds, like last resolved endpoints.
+	state *state.State
+
+	// Target groups of the previous round used to compute lifecycle events.
+	previousGroups map[string]*targetgroup.Group
+	publisher      *events.Publisher
+
+	// Number of peer targets of the previous round, excluding static targets.
+	previousPeers int
+
+	// Peers and source diagnostics of the last poll of the sources, updated
+	// by the following passes.
+	peers              map[string]*peerData
+	sourceDiagnostics  []clientinfo.Diagnostics
+	networkIDConflicts []string
+	// Sends targets to the adapter; set when Run starts.
+	stream *targetStream
+
+	// Forces the next pass to publish targets even if they haven't changed,
+	// like the first ones or after following the leader's.
+	forcePublish bool
+
+	// Sends alerts on discovery anomalies; nil if alerting is disabled.
+	notifier *alerting.Notifier
+
+	// Elects the instance scanning the network; nil if the election is
+	// disabled.
+	elector           election.Elector
+	leader            int32
+	leadershipChanged chan struct{}
+	// Modification time of the leader's result last published by the
+	// follower.
+	followedModTime time.Time
+
+	// Network health gauges; nil if the web server is disabled.
+	metrics *health.Metrics
+
+	// Connectivity graph of the last completed round.
+	graphMutex sync.RWMutex
+	graph      *graph.Graph
+
+	targetsMutex sync.RWMutex
+	targets      []*targetgroup.Group
+
+	// Closed when Run returns.
+	done chan struct{}
 }
 
 func init() {
@@ -100,9 +277,19 @@ func init() {
 
 	app.Flag(
 		"refresh.interval",
-		"Frequency for running the discovery.",
+		"Frequency of polling the sources for peers. New peers are resolved right away.",
 	).Default("5m").DurationVar(&config.refreshInterval)
 
+	app.Flag(
+		"refresh.verifyInterval",
+		"Frequency of verifying resolved diagnostics endpoints still serve their peers.",
+	).Default("5m").DurationVar(&config.verifyInterval)
+
+	app.Flag(
+		"refresh.scanInterval",
+		"Frequency of scanning ports of peers without a resolved diagnostics endpoint.",
+	).Default("15m").DurationVar(&config.scanInterval)
+
 	app.Flag(
 		"scan.range",
 		"A port range for diagnostics endpoint port scan.",
@@ -115,7 +302,7 @@ func init() {
 
 	app.Flag(
 		"scan.bannedAddress",
-		"Addresses excluded from the discovery.",
+		"Addresses excluded from the discovery: hostnames, IP addresses in any notation or CIDR blocks.",
 	).Default("").StringsVar(&config.bannedPeerAddresses)
 
 	app.Flag(
@@ -123,11 +310,201 @@ func init() {
 		"Allow private peers addresses for discovery (useful for internal network testing).",
 	).Default("false").BoolVar(&config.allowPrivateAddresses)
 
+	app.Flag(
+		"scan.allowLoopbackAddresses",
+		"Allow loopback peers addresses for discovery (useful for testing with peers running on the same host).",
+	).Default("false").BoolVar(&config.allowLoopbackAddresses)
+
+	app.Flag(
+		"scan.ipv6",
+		"Scan IPv6 addresses of peers; disable if the host has no IPv6 connectivity.",
+	).Default("true").BoolVar(&config.scanIPv6)
+
 	app.Flag(
 		"diagnostics.timeout",
 		"Timeout for diagnostics endpoint call.",
 	).Default("5s").DurationVar(&config.getDiagnosticsTimeout)
 
+	app.Flag(
+		"diagnostics.maxBodySize",
+		"Maximum size of a diagnostics endpoint response body.",
+	).Default("4MiB").BytesVar(&config.diagnosticsMaxBodySize)
+
+	app.Flag(
+		"diagnostics.idleConnTimeout",
+		"Time after which idle keep-alive connections to diagnostics endpoints are closed.",
+	).Default("90s").DurationVar(&config.diagnosticsIdleConnTimeout)
+
+	app.Flag(
+		"diagnostics.config",
+		"Path to a YAML file configuring TLS and authentication for diagnostics endpoint calls.",
+	).Default("").StringVar(&config.diagnosticsConfigFile)
+
+	app.Flag(
+		"relabel.config",
+		"Path to a YAML file with Prometheus relabel_configs applied to targets before they are exported.",
+	).Default("").StringVar(&config.relabelConfigFile)
+
+	app.Flag(
+		"geoip.cityDatabase",
+		"Path to a GeoLite2 City MMDB file used to set __meta_keep_geo_* labels.",
+	).Default("").StringVar(&config.geoipCityDatabase)
+
+	app.Flag(
+		"geoip.asnDatabase",
+		"Path to a GeoLite2 ASN MMDB file used to set __meta_keep_asn* labels.",
+	).Default("").StringVar(&config.geoipASNDatabase)
+
+	app.Flag(
+		"overrides.file",
+		"Path to a YAML file with fixed diagnostics endpoints and extra labels for known peers, and static targets.",
+	).Default("").StringVar(&config.overridesFile)
+
+	app.Flag(
+		"registry.file",
+		"Path to a CSV, JSON or YAML file with metadata of operators, exported as __meta_keep_operator_* labels.",
+	).Default("").StringVar(&config.registryFile)
+
+	app.Flag(
+		"watch.interval",
+		"Frequency of checking watched files for changes.",
+	).Default("10s").DurationVar(&config.watchInterval)
+
+	app.Flag(
+		"address.preference",
+		"Rule ordering the peer's network addresses for scanning; rules are applied in order of the flags. One of: "+strings.Join(utils.AddressRuleNames, ", ")+".",
+	).StringsVar(&config.addressPreference)
+
+	app.Flag(
+		"target.addressType",
+		"Address exported in the target's __address__: the network address the diagnostics endpoint has been found under (hostname) or the IP address the diagnostics have been served from (ip).",
+	).Default("hostname").EnumVar(&config.targetAddressType, "hostname", "ip")
+
+	app.Flag(
+		"target.path",
+		"Path served at the peer's endpoint exported as a separate target in the <kind>=<path> format, e.g. metrics=/metrics. The target is exported only if the path responds. Can be repeated. If not set, each peer has a single target.",
+	).StringsVar(&config.targetPaths)
+
+	app.Flag(
+		"target.sourceIdentity",
+		"Identity of the peers' target groups: chain address, target's address (endpoint), network ID or a hash of the labels set with --target.sourceLabels (labels). Peers missing the endpoint or network ID are identified by chain addresses.",
+	).Default(targetsource.ChainAddress).EnumVar(&config.sourceIdentity, targetsource.Strategies...)
+
+	app.Flag(
+		"target.sourceLabels",
+		"Target label hashed to identify the peers' target groups with the labels identity, after relabeling. Can be repeated.",
+	).StringsVar(&config.sourceIdentityLabels)
+
+	app.Flag(
+		"verify.networkID",
+		"Verify the network ID reported by the peer's diagnostics matches the one reported by the sources.",
+	).Default("false").BoolVar(&config.verifyNetworkID)
+
+	app.Flag(
+		"verify.handshake",
+		"Verify the peer's network ID with a libp2p handshake on its network port.",
+	).Default("false").BoolVar(&config.verifyHandshake)
+
+	app.Flag(
+		"verify.handshakeTimeout",
+		"Timeout for the libp2p handshake.",
+	).Default("10s").DurationVar(&config.verifyHandshakeTimeout)
+
+	app.Flag(
+		"history.file",
+		"Path to the peer history database. History is not recorded if empty.",
+	).Default("").StringVar(&config.historyFile)
+
+	app.Flag(
+		"web.listenAddress",
+		"Address to serve the HTTP endpoints on. The web server is disabled if empty.",
+	).Default("").StringVar(&config.webListenAddress)
+
+	app.Flag(
+		"events.log",
+		"Log peer lifecycle events.",
+	).Default("false").BoolVar(&config.eventsLog)
+
+	app.Flag(
+		"events.webhookURL",
+		"URL of a webhook to post peer lifecycle events to.",
+	).StringsVar(&config.eventsWebhookURLs)
+
+	app.Flag(
+		"events.webhookRetries",
+		"Number of retries of a failed webhook delivery.",
+	).Default("3").IntVar(&config.eventsWebhookRetries)
+
+	app.Flag(
+		"events.webhookTimeout",
+		"Timeout for a single webhook delivery.",
+	).Default("10s").DurationVar(&config.eventsWebhookTimeout)
+
+	app.Flag(
+		"alertmanager.url",
+		"Base URL of an Alertmanager to send alerts on discovery anomalies to.",
+	).StringsVar(&config.alertmanagerURLs)
+
+	app.Flag(
+		"alertmanager.timeout",
+		"Timeout for sending alerts to Alertmanager.",
+	).Default("10s").DurationVar(&config.alertmanagerTimeout)
+
+	app.Flag(
+		"alertmanager.peerDropThreshold",
+		"Fraction of peers that can disappear between rounds before an alert is sent.",
+	).Default("0.2").Float64Var(&config.alertmanagerPeerDropThreshold)
+
+	app.Flag(
+		"shutdown.timeout",
+		"Maximum time to wait for the discovery to stop on shutdown.",
+	).Default("30s").DurationVar(&config.shutdownTimeout)
+
+	app.Flag(
+		"stream.debounce",
+		"Time to collect targets resolved or lost in the middle of a pass before sending them to the output. Targets are sent only after each pass if 0.",
+	).Default("5s").DurationVar(&config.streamDebounce)
+
+	app.Flag(
+		"snapshot.seed",
+		"Snapshot of the discovery state to start with, exported with the snapshot export command.",
+	).Default("").StringVar(&config.snapshotSeed)
+
+	app.Flag(
+		"state.retention",
+		"Time after which peers no longer reported by the sources are removed from the discovery state.",
+	).Default("24h").DurationVar(&config.stateRetention)
+
+	app.Flag(
+		"election.method",
+		"Method of electing the instance scanning the network when running redundant instances: none, lockfile or lease.",
+	).Default("none").EnumVar(&config.electionMethod, "none", "lockfile", "lease")
+
+	app.Flag(
+		"election.path",
+		"Path to the lock file, or the path the lease files are named after, shared by the instances.",
+	).Default("").StringVar(&config.electionPath)
+
+	app.Flag(
+		"election.resultFile",
+		"Path to the file shared by the instances, where the leader publishes discovered targets for the followers.",
+	).Default("").StringVar(&config.electionResultFile)
+
+	app.Flag(
+		"election.id",
+		"Identity of the instance in the lease. Defaults to the hostname and the process ID.",
+	).Default("").StringVar(&config.electionID)
+
+	app.Flag(
+		"election.leaseDuration",
+		"Time after which the lease of a leader that stopped renewing it can be taken over.",
+	).Default("15s").DurationVar(&config.electionLeaseDuration)
+
+	app.Flag(
+		"election.renewInterval",
+		"Interval of campaigning for the leadership and of refreshing the followers' targets.",
+	).Default("5s").DurationVar(&config.electionRenewInterval)
+
 	app.Flag(
 		"log.json",
 		"Output logs in JSON format.",
@@ -135,19 +512,125 @@ func init() {
 }
 
 func newDiscovery() (*discovery, error) {
+	if config.refreshInterval <= 0 || config.verifyInterval <= 0 || config.scanInterval <= 0 {
+		return nil, fmt.Errorf("refresh intervals must be positive")
+	}
+
 	var err error
 	config.diagnosticsPortRange, err = utils.NewRange(scanPortRangeFlagValue)
 	if err != nil {
 		return nil, fmt.Errorf("invalid port range value provided %s: %v", scanPortRangeFlagValue, err)
 	}
 
+	var diagnosticsConfig *diagnostics.Config
+	if config.diagnosticsConfigFile != "" {
+		diagnosticsConfig, err = diagnostics.LoadConfig(config.diagnosticsConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load diagnostics config: %v", err)
+		}
+	}
+
+	clients, err := diagnostics.NewClients(diagnosticsConfig, diagnostics.Options{
+		Timeout:         config.getDiagnosticsTimeout,
+		MaxBodySize:     int64(config.diagnosticsMaxBodySize),
+		IdleConnTimeout: config.diagnosticsIdleConnTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create diagnostics clients: %v", err)
+	}
+
+	var overridesFile *watch.File[*overrides.Config]
+	if config.overridesFile != "" {
+		overridesFile, err = watch.NewFile(config.overridesFile, overrides.LoadConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load overrides: %v", err)
+		}
+	}
+
+	var registryFile *watch.File[*registry.Registry]
+	if config.registryFile != "" {
+		registryFile, err = watch.NewFile(config.registryFile, registry.Load)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load operators registry: %v", err)
+		}
+	}
+
+	var geoipDatabases *geoip.Databases
+	if config.geoipCityDatabase != "" || config.geoipASNDatabase != "" {
+		geoipDatabases, err = geoip.Open(config.geoipCityDatabase, config.geoipASNDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open geoip databases: %v", err)
+		}
+	}
+
+	var relabelConfigs []*relabel.Config
+	if config.relabelConfigFile != "" {
+		relabelConfig, err := relabeling.LoadConfig(config.relabelConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load relabel config: %v", err)
+		}
+		relabelConfigs = relabelConfig.RelabelConfigs
+	}
+
+	addressRules := make([]utils.AddressRule, 0, len(config.addressPreference))
+	for _, rule := range config.addressPreference {
+		addressRule, err := utils.ParseAddressRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address preference: %v", err)
+		}
+		addressRules = append(addressRules, addressRule)
+	}
+
+	discoveryState := state.New()
+	if config.snapshotSeed != "" {
+		snapshot, err := state.Load(config.snapshotSeed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot: %v", err)
+		}
+		discoveryState.Restore(snapshot)
+	}
+
+	endpointPaths, err := parseEndpointPaths(config.targetPaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target path: %v", err)
+	}
+
+	sourceIdentity, err := targetsource.New(config.sourceIdentity, config.sourceIdentityLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target source identity: %v", err)
+	}
+
+	var verifier *identity.Verifier
+	if config.verifyHandshake {
+		verifier, err = identity.NewVerifier()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create identity verifier: %v", err)
+		}
+	}
+
 	cd := &discovery{
-		oldSourceList: make(map[string]bool),
+		clients:           clients,
+		overrides:         overridesFile,
+		registry:          registryFile,
+		geoip:             geoipDatabases,
+		rerun:             make(chan struct{}, 1),
+		relabelConfigs:    relabelConfigs,
+		endpointPaths:     endpointPaths,
+		addressRules:      addressRules,
+		verifier:          verifier,
+		state:             discoveryState,
+		sourceIdentity:    sourceIdentity,
+		sources:           targetsource.NewTracker(),
+		previousGroups:    make(map[string]*targetgroup.Group),
+		publisher:         events.NewPublisher(),
+		done:              make(chan struct{}),
+		leadershipChanged: make(chan struct{}, 1),
+		forcePublish:      true,
 	}
 	return cd, nil
 }
 
-func (d *discovery) collectDiagnostics(addresses []string) []clientinfo.Diagnostics {
+func (d *discovery) collectDiagnostics(ctx context.Context, addresses []string) []clientinfo.Diagnostics {
 	var allDiagnostics = make([]clientinfo.Diagnostics, 0)
 
 	for _, address := range addresses {
@@ -155,29 +638,42 @@ func (d *discovery) collectDiagnostics(addresses []string) []clientinfo.Diagnost
 			"msg", fmt.Sprintf("collecting diagnostics from source %s", address),
 		)
 
-		diagnostics, err := getDiagnostics(address)
+		sourceDiagnostics, err := d.clients.ForSource(address).Get(ctx, address)
 		if err != nil {
 			level.Error(logger).Log(
 				"msg", "failed to get diagnostics",
 				"from", address,
+				"category", diagnostics.CategoryOf(err),
 				"err", err,
 			)
+			d.state.RecordSource(address, "", 0, false, time.Now())
 			continue
 		}
+		d.state.RecordSource(
+			address,
+			sourceDiagnostics.ClientInfo.ChainAddress,
+			len(sourceDiagnostics.ConnectedPeers),
+			true,
+			time.Now(),
+		)
 
-		allDiagnostics = append(allDiagnostics, diagnostics)
+		allDiagnostics = append(allDiagnostics, sourceDiagnostics)
 	}
 
 	return allDiagnostics
 }
 
+// combineDiscoveredPeers combines peers reported by the diagnostics sources.
+// It returns the peers and chain addresses of peers with conflicting network
+// IDs reported.
 func (d *discovery) combineDiscoveredPeers(
 	allDiagnostics []clientinfo.Diagnostics,
-) map[string]*peerData {
-	var peersNetworkIDs = make(map[string]string, 0)                // chain address -> network id
-	var peersAddressesSet = make(map[string]map[string]struct{}, 0) // chain address -> []network addresses set
-	var peersNetworkPorts = make(map[string]int, 0)                 // chain address -> network port
+) (map[string]*peerData, []string) {
+	var peersNetworkIDs = make(map[string]string, 0)           // chain address -> network id
+	var peersAddressesSet = make(map[string]map[string]int, 0) // chain address -> network address -> sources count
+	var peersNetworkPorts = make(map[string]int, 0)            // chain address -> network port
 	var peers = make(map[string]*peerData, 0)
+	var networkIDConflicts = make(map[string]struct{})
 
 	for _, diagnostics := range allDiagnostics {
 		for _, peer := range diagnostics.ConnectedPeers {
@@ -191,13 +687,16 @@ func (d *discovery) combineDiscoveredPeers(
 					"previous", peersNetworkIDs[peer.ChainAddress],
 					"current", peer.NetworkID,
 				)
+				networkIDConflicts[peer.ChainAddress] = struct{}{}
 				continue
 			} else {
 				peersNetworkIDs[peer.ChainAddress] = peer.NetworkID
 			}
 
 			// In case diagnostics sources know different addresses for the peer
-			// we want to combine them in a set.
+			// we want to combine them in a set, counting sources reporting
+			// each address.
+			reportedAddresses := make(map[string]struct{})
 			for _, peerMultiAddress := range peer.NetworkMultiAddresses {
 				peerAddress, peerNetworkPort, err := utils.ExtractAddressFromMultiAddress(peerMultiAddress)
 				if err != nil {
@@ -210,10 +709,13 @@ func (d *discovery) combineDiscoveredPeers(
 				}
 
 				if _, ok := peersAddressesSet[peer.ChainAddress]; !ok {
-					peersAddressesSet[peer.ChainAddress] = make(map[string]struct{})
+					peersAddressesSet[peer.ChainAddress] = make(map[string]int)
 				}
 
-				peersAddressesSet[peer.ChainAddress][peerAddress] = struct{}{}
+				if _, ok := reportedAddresses[peerAddress]; !ok {
+					reportedAddresses[peerAddress] = struct{}{}
+					peersAddressesSet[peer.ChainAddress][peerAddress]++
+				}
 
 				if peerNetworkPort > 0 {
 					// A peer can operate on only one network port, so we're not
@@ -229,68 +731,161 @@ func (d *discovery) combineDiscoveredPeers(
 	// to gather the results. Here we convert the mapping to a slice that will
 	// be considered a set.
 	for chainAddress, networkAddresses := range peersAddressesSet {
-		networkAddressesSet := make([]string, 0, len(networkAddresses))
-		for k := range networkAddresses {
-			networkAddressesSet = append(networkAddressesSet, k)
+		networkAddressesSet := make([]utils.AddressInfo, 0, len(networkAddresses))
+		for address, sources := range networkAddresses {
+			networkAddressesSet = append(networkAddressesSet, utils.AddressInfo{
+				Address: address,
+				Sources: sources,
+			})
 		}
 
 		peers[chainAddress] = &peerData{
 			ChainAddress:     chainAddress,
 			NetworkID:        peersNetworkIDs[chainAddress],
-			NetworkAddresses: utils.SortAddresses(networkAddressesSet),
+			NetworkAddresses: utils.SortAddressesByPreference(networkAddressesSet, d.addressRules),
 			NetworkPort:      peersNetworkPorts[chainAddress],
 		}
 	}
 
-	return peers
-}
+	conflicts := make([]string, 0, len(networkIDConflicts))
+	for chainAddress := range networkIDConflicts {
+		conflicts = append(conflicts, chainAddress)
+	}
+	sort.Strings(conflicts)
 
-// Convert a peer details to a Prometheus' target.
-func (p *peerData) createPeerTarget() (targetGroup targetgroup.Group) {
-	targetGroup.Source = p.ChainAddress // TODO: Maybe we should use endpoint here?
+	return peers, conflicts
+}
 
-	targetGroup.Targets = []model.LabelSet{
-		{
-			model.AddressLabel: model.LabelValue(p.ClientInfoEndpoint),
-		},
-	}
-	targetGroup.Labels = model.LabelSet{
-		model.AddressLabel:                 model.LabelValue(p.ClientInfoEndpoint),
+// Convert a peer details to Prometheus' targets, one for each endpoint path
+// verified to respond if paths are configured, or a single one otherwise. The
+// relabeling rules are applied to each target's labels; targets dropped by the
+// rules are omitted.
+func (d *discovery) createPeerTargets(p *peerData) []*targetgroup.Group {
+	labels := model.LabelSet{
+		model.AddressLabel:                 model.LabelValue(p.targetAddress()),
 		model.LabelName(labelChainAddress): model.LabelValue(p.ChainAddress),
 		model.LabelName(labelNetworkID):    model.LabelValue(p.NetworkID),
 	}
-	return
+	if p.Verified != "" {
+		labels[model.LabelName(labelVerified)] = model.LabelValue(p.Verified)
+	}
+	if p.Version != "" {
+		labels[model.LabelName(labelVersion)] = model.LabelValue(p.Version)
+	}
+	for name, value := range p.GeoLabels {
+		labels[name] = value
+	}
+	for name, value := range p.OperatorLabels {
+		labels[name] = value
+	}
+	for name, value := range p.ExtraLabels {
+		labels[name] = value
+	}
+
+	if len(d.endpointPaths) == 0 {
+		if targetGroup, keep := d.createTarget(p, labels, ""); keep {
+			return []*targetgroup.Group{targetGroup}
+		}
+		return nil
+	}
+
+	targetGroups := make([]*targetgroup.Group, 0, len(p.EndpointPaths))
+	for _, path := range p.EndpointPaths {
+		pathLabels := labels.Clone()
+		pathLabels[model.MetricsPathLabel] = model.LabelValue(path.Path)
+		pathLabels[model.LabelName(labelEndpointKind)] = model.LabelValue(path.Kind)
+
+		if targetGroup, keep := d.createTarget(p, pathLabels, path.Kind); keep {
+			targetGroups = append(targetGroups, targetGroup)
+		}
+	}
+	return targetGroups
 }
 
-func getDiagnostics(addressWithPort string) (clientinfo.Diagnostics, error) {
-	var diagnostics clientinfo.Diagnostics
-	client := http.Client{
-		Timeout: config.getDiagnosticsTimeout,
+// createTarget applies the relabeling rules to the target's labels and puts
+// the target in a group with a source derived by the source identity. It
+// returns false if the target has been dropped by the rules.
+func (d *discovery) createTarget(
+	p *peerData,
+	labels model.LabelSet,
+	kind string,
+) (*targetgroup.Group, bool) {
+	labels, keep := relabeling.Process(labels, d.relabelConfigs)
+	if !keep {
+		return nil, false
 	}
 
-	if addressWithPort == "" {
-		return diagnostics, fmt.Errorf("address is empty")
+	return &targetgroup.Group{
+		Source: d.sourceIdentity.Source(targetsource.Peer{
+			ChainAddress: p.ChainAddress,
+			NetworkID:    p.NetworkID,
+			Endpoint:     string(labels[model.AddressLabel]),
+			Labels:       labels,
+			Kind:         kind,
+		}),
+		Targets: []model.LabelSet{
+			{
+				model.AddressLabel: labels[model.AddressLabel],
+			},
+		},
+		Labels: labels,
+	}, true
+}
+
+// targetAddress returns the address exported in the target's __address__
+// label according to the configured address type. The IP address is used only
+// if it is known; diagnostics fetched through a proxy don't reveal it.
+func (p *peerData) targetAddress() string {
+	if config.targetAddressType != "ip" || p.EndpointIP == "" {
+		return p.ClientInfoEndpoint
 	}
 
-	resp, err := client.Get(fmt.Sprintf("http://%s/diagnostics", addressWithPort))
+	_, port, err := net.SplitHostPort(p.ClientInfoEndpoint)
 	if err != nil {
-		return diagnostics, fmt.Errorf("failed to get diagnostics: %v", err)
+		return p.ClientInfoEndpoint
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&diagnostics); err != nil {
-		return diagnostics, fmt.Errorf("failed to decode diagnostics: %v", err)
+	return net.JoinHostPort(p.EndpointIP, port)
+}
+
+// getPeerDiagnostics calls the diagnostics endpoint of the peer with the client
+// configured for the peer. It returns the IP address the diagnostics have been
+// served from, if known.
+func (d *discovery) getPeerDiagnostics(
+	ctx context.Context,
+	peer *peerData,
+	endpoint string,
+) (clientinfo.Diagnostics, string, error) {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return clientinfo.Diagnostics{}, "", fmt.Errorf("invalid endpoint %s: %v", endpoint, err)
 	}
 
-	return diagnostics, nil
+	return d.clients.ForPeer(peer.ChainAddress, host).GetWithRemoteIP(ctx, endpoint)
 }
 
 func isAddressExcluded(address string) bool {
-	if slices.Contains(config.bannedPeerAddresses, address) {
-		return true
+	for _, bannedAddress := range config.bannedPeerAddresses {
+		if utils.MatchesAddress(address, bannedAddress) {
+			return true
+		}
 	}
 
 	if ip := net.ParseIP(address); ip != nil {
-		if ip.IsLoopback() {
+		if ip.IsLoopback() && !config.allowLoopbackAddresses {
+			return true
+		}
+
+		// Unspecified, multicast and link-local addresses can't be dialed
+		// from another host. Peers commonly announce IPv6 link-local
+		// addresses, which are only meaningful on their own link.
+		if ip.IsUnspecified() ||
+			ip.IsMulticast() ||
+			ip.IsLinkLocalUnicast() {
+			return true
+		}
+
+		if ip.To4() == nil && !config.scanIPv6 {
 			return true
 		}
 
@@ -302,247 +897,491 @@ func isAddressExcluded(address string) bool {
 	return false
 }
 
-// Run is an implementation of the Discovery interface.
-func (d *discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
-discoveryLoop:
-	for c := time.Tick(config.refreshInterval); ; {
-		// Get diagnostics from the source nodes (bootstrap nodes) to resolve
-		// the list of connected peers.
-		sourceDiagnostics := d.collectDiagnostics(config.listenAddresses)
-
-		// Combine results received from the source nodes to resolve a set of unique
-		// peers.
-		peers := d.combineDiscoveredPeers(sourceDiagnostics)
+// stepRecorder is notified about the outcome of each step taken while
+// resolving the diagnostics endpoint of a peer. It is used by the probe
+// command to build a step-by-step report.
+type stepRecorder func(step string, target string, startedAt time.Time, err error)
+
+// resolveEndpointAtAddress looks for the diagnostics endpoint of the peer under
+// the given network address. It returns true and sets the peer's
+// ClientInfoEndpoint if the endpoint has been found.
+func (d *discovery) resolveEndpointAtAddress(
+	ctx context.Context,
+	peerLogger log.Logger,
+	peer *peerData,
+	networkAddress string,
+	discoveredPorts map[string]map[string]int, // network address -> chain address -> port
+	record stepRecorder,
+) bool {
+	if record == nil {
+		record = func(string, string, time.Time, error) {}
+	}
 
-		level.Info(logger).Log(
-			"msg", fmt.Sprintf("discovered %d connected peers", len(peers)),
+	// Check if the network address is excluded (banned, loopback or internal)
+	startedAt := time.Now()
+	if isAddressExcluded(networkAddress) {
+		record("exclusion check", networkAddress, startedAt, fmt.Errorf("address is excluded"))
+		level.Warn(peerLogger).Log(
+			"msg", "address is excluded from scanning",
+			"networkAddress", networkAddress,
 		)
-		level.Debug(logger).Log(
-			"peers", fmt.Sprintf("%+v", peers),
+		return false
+	}
+	record("exclusion check", networkAddress, startedAt, nil)
+
+	if _, ok := discoveredPorts[networkAddress]; !ok {
+		discoveredPorts[networkAddress] = make(map[string]int)
+	}
+
+	// Check if the network address is reachable.
+	startedAt = time.Now()
+	dialer := d.clients.ForPeer(peer.ChainAddress, networkAddress).Dialer()
+	err := utils.CheckPortOpen(ctx, dialer, "tcp", networkAddress, peer.NetworkPort, config.scanPortTimeout)
+	record(
+		"network port reachability",
+		net.JoinHostPort(networkAddress, fmt.Sprintf("%d", peer.NetworkPort)),
+		startedAt,
+		err,
+	)
+	if err != nil {
+		level.Warn(peerLogger).Log(
+			"msg", "network address is not reachable",
+			"address", networkAddress,
+			"networkPort", peer.NetworkPort,
 		)
+		return false
+	}
+	level.Info(peerLogger).Log(
+		"msg", "address is reachable under network port",
+		"address", networkAddress,
+		"networkPort", peer.NetworkPort)
+
+	checkPort := func(port int) error {
+		endpoint := net.JoinHostPort(networkAddress, fmt.Sprintf("%d", port))
+
+		// Check if the port is open.
+		startedAt := time.Now()
+		if err := utils.CheckPortOpen(ctx, dialer, "tcp", networkAddress, port, config.scanPortTimeout); err != nil {
+			record("port open", endpoint, startedAt, err)
+			return fmt.Errorf("port %d is not open", port)
+		}
+		record("port open", endpoint, startedAt, nil)
 
-		// TODO: Try use https://github.com/Ullaakut/nmap for ports scanning
+		// The port is open, check if this is the correct diagnostics
+		// endpoint for the peer.
+		startedAt = time.Now()
+		diagnostics, remoteIP, err := d.getPeerDiagnostics(ctx, peer, endpoint)
+		record("diagnostics fetch", endpoint, startedAt, err)
+		if err != nil {
+			return fmt.Errorf("failed to get diagnostics: %w", err)
+		}
 
-		// network address -> chain address -> port
-		discoveredPorts := make(map[string]map[string]int)
+		// Store discovered port to use for discovery of other peers
+		// running at the same address.
+		discoveredPorts[networkAddress][diagnostics.ClientInfo.ChainAddress] = port
 
-	peerLoop:
-		for _, peer := range peers {
-			peerLogger := log.With(logger, "peer", peer.ChainAddress)
+		// Check if this port serves diagnostics for the peer we're
+		// looking for.
+		startedAt = time.Now()
+		if peer.ChainAddress != diagnostics.ClientInfo.ChainAddress {
+			err := fmt.Errorf(
+				"port serves another peer: %s", diagnostics.ClientInfo.ChainAddress,
+			)
+			record("chain address match", endpoint, startedAt, err)
+			return err
+		}
+		record("chain address match", endpoint, startedAt, nil)
+
+		// We've got a correct diagnostics target endpoint for the peer.
+		peer.ClientInfoEndpoint = endpoint
+		peer.EndpointIP = remoteIP
+		peer.Version = diagnostics.ClientInfo.Version
+		peer.DiagnosticsNetworkID = diagnostics.ClientInfo.NetworkID
+		peer.ConnectedPeers = connectedPeers(diagnostics)
+		return nil
+	}
 
+	// TODO: Test this on test environment when multiple nodes are
+	// running at the same address.
+	// Check if a port has been already discovered when looping ports
+	// for another peer. This case is path is meant for peers running
+	// sharing the same network address under different ports.
+	if port, ok := discoveredPorts[networkAddress][peer.ChainAddress]; ok {
+		err := checkPort(port)
+		if err == nil {
 			level.Info(peerLogger).Log(
-				"msg", "resolving diagnostics endpoint target for peer",
+				"msg", "found diagnostics port",
+				"address", networkAddress,
+				"port", port,
 			)
+			return true
+		}
+		level.Warn(peerLogger).Log(
+			"msg", "failed to check port",
+			"address", networkAddress,
+			"port", port,
+			"err", err,
+		)
+		// The port is not correct; proceed to the ports scanning loop.
+	}
 
-			// Check if the already known endpoint still works.
-			if peer.ClientInfoEndpoint != "" {
-				diagnostics, err := getDiagnostics(peer.ClientInfoEndpoint)
-				if err == nil {
-					if peer.ChainAddress == diagnostics.ClientInfo.ChainAddress {
-						level.Info(peerLogger).Log(
-							"msg", "already known endpoint still works",
-							"endpoint", peer.ClientInfoEndpoint,
-						)
-						// The endpoint still works, move to the next peer.
-						continue peerLoop
-					}
-				}
-
-				level.Warn(peerLogger).Log(
-					"msg", "already known endpoint doesn't work",
-					"endpoint", peer.ClientInfoEndpoint,
-				)
-			}
+	// Scan ports range, starting with ports other endpoints have been found
+	// on.
+	for _, port := range d.state.PortOrder(config.diagnosticsPortRange.Start, config.diagnosticsPortRange.End) {
+		if ctx.Err() != nil {
+			return false
+		}
 
-			// Loop all discovered network addresses of the peer.
-		addressLoop:
-			for _, networkAddress := range peer.NetworkAddresses {
-				// Check if the network address is excluded (banned, loopback or internal)
-				if isAddressExcluded(networkAddress) {
-					level.Warn(peerLogger).Log(
-						"msg", "address is excluded from scanning",
-						"networkAddress", networkAddress,
-					)
-					// The address is excluded, continue to the next discovered
-					// peer's network address.
-					continue addressLoop
-				}
+		level.Debug(peerLogger).Log("msg", "scanning port", "address", networkAddress, "port", port)
 
-				if _, ok := discoveredPorts[networkAddress]; !ok {
-					discoveredPorts[networkAddress] = make(map[string]int)
-				}
+		err := checkPort(port)
+		if err != nil {
+			level.Warn(peerLogger).Log("msg", "failed to check port", "address", networkAddress, "port", port, "err", err)
+			continue
+		}
+		level.Info(peerLogger).Log("msg", "found diagnostics port", "address", networkAddress, "port", port)
+		d.state.RecordPortFound(port)
 
-				// Check if the network address is reachable.
-				isReachable := utils.IsPortOpen("tcp", networkAddress, peer.NetworkPort, config.scanPortTimeout)
-				if !isReachable {
-					level.Warn(peerLogger).Log(
-						"msg", "network address is not reachable",
-						"address", networkAddress,
-						"networkPort", peer.NetworkPort,
-					)
-					// The address is not reachable, continue to the next discovered
-					// peer's network address.
-					continue addressLoop
-				} else {
-					level.Info(peerLogger).Log(
-						"msg", "address is reachable under network port",
-						"address", networkAddress,
-						"networkPort", peer.NetworkPort)
-				}
+		return true
+	}
 
-				checkPort := func(port int) error {
-					// Check if the port is open.
-					if !utils.IsPortOpen("tcp", networkAddress, port, config.scanPortTimeout) {
-						return fmt.Errorf("port %d is not open", port)
-					}
-
-					// The port is open, check if this is the correct diagnostics
-					// endpoint for the peer.
-
-					endpoint := net.JoinHostPort(networkAddress, fmt.Sprintf("%d", port))
-					diagnostics, err := getDiagnostics(endpoint)
-					if err != nil {
-						return fmt.Errorf("failed to get diagnostics: %v", err)
-					}
-
-					// Store discovered port to use for discovery of other peers
-					// running at the same address.
-					discoveredPorts[networkAddress][diagnostics.ClientInfo.ChainAddress] = port
-
-					// Check if this port serves diagnostics for the peer we're
-					// looking for.
-					if peer.ChainAddress != diagnostics.ClientInfo.ChainAddress {
-						return fmt.Errorf(
-							"port serves another peer: %s", diagnostics.ClientInfo.ChainAddress,
-						)
-					}
-
-					// We've got a correct diagnostics target endpoint for the peer.
-					peer.ClientInfoEndpoint = endpoint
-					return nil
-				}
+	return false
+}
 
-				// TODO: Test this on test environment when multiple nodes are
-				// running at the same address.
-				// Check if a port has been already discovered when looping ports
-				// for another peer. This case is path is meant for peers running
-				// sharing the same network address under different ports.
-				if port, ok := discoveredPorts[networkAddress][peer.ChainAddress]; ok {
-					err := checkPort(port)
-					if err == nil {
-						level.Info(peerLogger).Log(
-							"msg", "found diagnostics port",
-							"address", networkAddress,
-							"port", port,
-						)
-						// We've got correct address and port for the peer; move to another peer.
-						continue peerLoop
-					}
-					level.Warn(peerLogger).Log(
-						"msg", "failed to check port",
-						"address", networkAddress,
-						"port", port,
-						"err", err,
-					)
-					// The port is not correct; proceed to the ports scanning loop.
-				}
+// checkKnownEndpoint checks the peer's known diagnostics endpoint still serves
+// the peer's diagnostics and updates the peer's data from them. It returns
+// true if the endpoint still works.
+func (d *discovery) checkKnownEndpoint(ctx context.Context, peerLogger log.Logger, peer *peerData) bool {
+	diagnostics, remoteIP, err := d.getPeerDiagnostics(ctx, peer, peer.ClientInfoEndpoint)
+	if err == nil && peer.ChainAddress == diagnostics.ClientInfo.ChainAddress {
+		peer.EndpointIP = remoteIP
+		peer.Version = diagnostics.ClientInfo.Version
+		peer.DiagnosticsNetworkID = diagnostics.ClientInfo.NetworkID
+		peer.ConnectedPeers = connectedPeers(diagnostics)
+		level.Info(peerLogger).Log(
+			"msg", "already known endpoint still works",
+			"endpoint", peer.ClientInfoEndpoint,
+		)
+		return true
+	}
 
-				// Scan ports range.
-			portLoop:
-				for port := config.diagnosticsPortRange.Start; port <= config.diagnosticsPortRange.End; port++ {
-					level.Debug(peerLogger).Log("msg", "scanning port", "address", networkAddress, "port", port)
+	level.Warn(peerLogger).Log(
+		"msg", "already known endpoint doesn't work",
+		"endpoint", peer.ClientInfoEndpoint,
+	)
+	return false
+}
 
-					err := checkPort(port)
-					if err != nil {
-						level.Warn(peerLogger).Log("msg", "failed to check port", "address", networkAddress, "port", port, "err", err)
-						continue portLoop
-					}
-					level.Info(peerLogger).Log("msg", "found diagnostics port", "address", networkAddress, "port", port)
+// resolvePeer finds the diagnostics endpoint of the peer. It returns true if
+// the endpoint has been found.
+func (d *discovery) resolvePeer(
+	ctx context.Context,
+	peerLogger log.Logger,
+	peer *peerData,
+	discoveredPorts map[string]map[string]int, // network address -> chain address -> port
+) bool {
+	level.Info(peerLogger).Log(
+		"msg", "resolving diagnostics endpoint target for peer",
+	)
 
-					// We've got correct address and port for the peer, let's resolve another peer.
-					continue peerLoop
-				}
-			}
+	// Check if the already known endpoint still works.
+	if peer.ClientInfoEndpoint != "" && d.checkKnownEndpoint(ctx, peerLogger, peer) {
+		return true
+	}
 
-			level.Error(peerLogger).Log(
-				"msg", "failed to find diagnostics port",
-				"networkAddresses", fmt.Sprintf("%s", peer.NetworkAddresses))
+	// Loop all discovered network addresses of the peer.
+	for _, networkAddress := range peer.NetworkAddresses {
+		if d.resolveEndpointAtAddress(ctx, peerLogger, peer, networkAddress, discoveredPorts, nil) {
+			// We've got correct address and port for the peer.
+			return true
 		}
+	}
 
-		// Note that we treat errors when querying specific node as fatal for this
-		// iteration of the time.Tick loop. It's better to have some stale targets than an incomplete
-		// list of targets simply because there may have been a timeout. If the service is actually
-		// gone as far as consul is concerned, that will be picked up during the next iteration of
-		// the outer loop.
+	level.Error(peerLogger).Log(
+		"msg", "failed to find diagnostics port",
+		"networkAddresses", fmt.Sprintf("%s", peer.NetworkAddresses))
 
-		newSourceList := make(map[string]bool)
+	return false
+}
 
-		level.Info(logger).Log(
-			"msg", fmt.Sprintf("discovery round completed with %d peers", len(peers)),
-		)
+// Run is an implementation of the Discovery interface. The sources are polled,
+// known endpoints verified and unresolved peers scanned on separate schedules;
+// targets are published after each pass.
+func (d *discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	defer close(d.done)
+
+	d.stream = newTargetStream(ctx, ch, config.streamDebounce)
+
+	pollTicker := time.NewTicker(config.refreshInterval)
+	defer pollTicker.Stop()
+	verifyTicker := time.NewTicker(config.verifyInterval)
+	defer verifyTicker.Stop()
+	scanTicker := time.NewTicker(config.scanInterval)
+	defer scanTicker.Stop()
+
+	pass := pollPass
+	for {
+		// Followers don't scan the network; they publish the leader's result
+		// until they become the leader.
+		if d.elector != nil && !d.isLeader() {
+			if !d.followLeader() {
+				return
+			}
 
-		tgs := make([]*targetgroup.Group, len(peers))
-		for _, peer := range peers {
-			target := peer.createPeerTarget()
-			tgs = append(tgs, &target)
+			// Start with polling the sources once elected.
+			pass = pollPass
+			select {
+			case <-time.After(config.electionRenewInterval):
+			case <-d.leadershipChanged:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
 
-			newSourceList[target.Source] = true
+		startedAt := time.Now()
+		switch pass {
+		case pollPass:
+			d.poll(ctx)
+		case verifyPass:
+			d.verifyEndpoints(ctx)
+		case scanPass:
+			d.scanUnresolved(ctx)
 		}
 
-		// TODO: Test if it works as expected
-		// When a target disappears, send an update with empty targetList.
-		for key := range d.oldSourceList {
-			if !newSourceList[key] {
-				tgs = append(tgs, &targetgroup.Group{
-					Source: key,
-				})
-			}
+		// The pass has been interrupted so its results are incomplete. We don't
+		// publish them to keep the last complete pass's targets in the output.
+		if ctx.Err() != nil {
+			level.Info(logger).Log("msg", "discovery pass interrupted", "pass", pass)
+			return
 		}
-		d.oldSourceList = newSourceList
 
-		// We're returning all peer nodes targets as a single target group.
-		ch <- tgs
+		if !d.publish(ctx, pass, startedAt) {
+			return
+		}
 
-		// Wait for ticker to start a next discovery round or exit when ctx is closed.
+		// Wait for the next pass to be due or exit when ctx is closed.
 		select {
-		case <-c:
-			continue discoveryLoop
+		case <-pollTicker.C:
+			pass = pollPass
+		case <-verifyTicker.C:
+			pass = verifyPass
+		case <-scanTicker.C:
+			pass = scanPass
+		case <-d.rerun:
+			level.Info(logger).Log("msg", "watched file changed; running discovery")
+			pass = pollPass
+		case <-d.leadershipChanged:
+			pass = pollPass
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// setTargets stores the target groups of the last completed discovery round.
+func (d *discovery) setTargets(tgs []*targetgroup.Group) {
+	d.targetsMutex.Lock()
+	defer d.targetsMutex.Unlock()
+
+	d.targets = tgs
+}
+
+// updateTargets replaces the stored target group with the same source as the
+// partially updated one, or adds it. The stored group is removed if the
+// updated one is empty.
+func (d *discovery) updateTargets(tg *targetgroup.Group) {
+	d.targetsMutex.Lock()
+	defer d.targetsMutex.Unlock()
+
+	targets := make([]*targetgroup.Group, 0, len(d.targets)+1)
+	for _, target := range d.targets {
+		if target.Source != tg.Source {
+			targets = append(targets, target)
+		}
+	}
+	if len(tg.Targets) > 0 {
+		targets = append(targets, tg)
+	}
+	d.targets = targets
+}
+
+// Targets returns the target groups of the last completed discovery round.
+func (d *discovery) Targets() []*targetgroup.Group {
+	d.targetsMutex.RLock()
+	defer d.targetsMutex.RUnlock()
+
+	return d.targets
+}
+
 func main() {
 	app.HelpFlag.Short('h')
 
-	_, err := app.Parse(os.Args[1:])
+	command, err := app.Parse(os.Args[1:])
 	if err != nil {
 		fmt.Println("err: ", err)
 		return
 	}
 
+	// Commands other than run print their results to the standard output, so
+	// their logs go to the standard error not to mix with the results.
+	logOutput := os.Stdout
+	if command != runCmd.FullCommand() {
+		logOutput = os.Stderr
+	}
+
 	var baseLogger log.Logger
 	if config.logJson {
-		baseLogger = log.NewJSONLogger(os.Stdout)
+		baseLogger = log.NewJSONLogger(logOutput)
 	} else {
-		baseLogger = log.NewLogfmtLogger(os.Stdout)
+		baseLogger = log.NewLogfmtLogger(logOutput)
 	}
 
 	logger = log.NewSyncLogger(baseLogger)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
-	ctx := context.Background()
+	// Cancel the context on SIGINT or SIGTERM to shut down gracefully.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case runCmd.FullCommand():
+		runDiscovery(ctx)
+	case graphCmd.FullCommand():
+		err = runGraph(ctx, os.Stdout)
+	case probeCmd.FullCommand():
+		err = runProbe(ctx, os.Stdout)
+	case snapshotExportCmd.FullCommand():
+		err = runSnapshotExport(ctx, os.Stdout)
+	case rulesGenerateCmd.FullCommand():
+		err = runRulesGenerate(os.Stdout)
+	case dashboardGenerateCmd.FullCommand():
+		err = runDashboardGenerate(os.Stdout)
+	case historyPeersCmd.FullCommand():
+		err = runHistoryPeers(os.Stdout)
+	case historyChurnCmd.FullCommand():
+		err = runHistoryChurn(os.Stdout)
+	case historyUptimeCmd.FullCommand():
+		err = runHistoryUptime(os.Stdout)
+	case historyChangesCmd.FullCommand():
+		err = runHistoryChanges(os.Stdout)
+	}
+	if err != nil {
+		fmt.Println("err: ", err)
+		os.Exit(1)
+	}
+}
 
+func runDiscovery(ctx context.Context) {
 	disc, err := newDiscovery()
 	if err != nil {
 		panic(fmt.Errorf("failed to initiate discovery: %v", err))
 	}
-	sdAdapter := adapter.NewAdapter(ctx, config.outputFile, "keepNetworkPeerSD", disc, logger)
+	if disc.geoip != nil {
+		defer disc.geoip.Close()
+	}
+
+	mux := http.NewServeMux()
+
+	consumers := make([]events.Consumer, 0)
+	if config.eventsLog {
+		consumers = append(consumers, events.NewLogConsumer(logger))
+	}
+	for _, url := range config.eventsWebhookURLs {
+		consumers = append(consumers, events.NewWebhookConsumer(
+			ctx,
+			url,
+			config.eventsWebhookTimeout,
+			config.eventsWebhookRetries,
+			time.Second,
+			logger,
+		))
+	}
+	if config.webListenAddress != "" {
+		sseConsumer := events.NewSSEConsumer()
+		consumers = append(consumers, sseConsumer)
+		mux.Handle("/events", sseConsumer)
+		mux.HandleFunc("/graph", disc.serveGraph)
+		mux.HandleFunc("/graph/stats", disc.serveGraphStats)
+		mux.HandleFunc("/snapshot", disc.serveSnapshot)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		disc.metrics = health.NewMetrics(registry)
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	}
+	disc.publisher = events.NewPublisher(consumers...)
+
+	if len(config.alertmanagerURLs) > 0 {
+		// Alerts are re-sent each round, so they expire if the discovery
+		// stops without resolving them.
+		disc.notifier = alerting.NewNotifier(
+			alerting.NewClient(config.alertmanagerURLs, config.alertmanagerTimeout),
+			3*config.refreshInterval,
+		)
+	}
+
+	if config.webListenAddress != "" {
+		serveWeb(ctx, config.webListenAddress, mux)
+	}
+
+	if config.electionMethod != "none" {
+		elector, err := newElector()
+		if err != nil {
+			panic(fmt.Errorf("failed to initiate leader election: %v", err))
+		}
+		disc.elector = elector
+		go election.Watch(ctx, elector, config.electionRenewInterval, logger, disc.setLeader)
+	}
+
+	if disc.overrides != nil {
+		go disc.overrides.Watch(ctx, config.watchInterval, logger, disc.triggerRound)
+	}
+	if disc.registry != nil {
+		go disc.registry.Watch(ctx, config.watchInterval, logger, disc.triggerRound)
+	}
+
 	fmt.Printf("FILE: %s\n", config.outputFile)
-	sdAdapter.Run()
+	outputDone := runOutput(ctx, config.outputFile, disc)
 
 	<-ctx.Done()
+
+	level.Info(logger).Log("msg", "shutting down discovery")
+
+	// Wait for the discovery to stop, so we know targets of the last completed
+	// round won't change anymore.
+	select {
+	case <-disc.done:
+	case <-time.After(config.shutdownTimeout):
+		level.Warn(logger).Log(
+			"msg", "discovery didn't stop before shutdown timeout",
+			"timeout", config.shutdownTimeout,
+		)
+	}
+
+	// Updates are throttled, so the last round's targets may not be written
+	// yet. Flush them to the output file once the writer has stopped, unless
+	// no round has been completed.
+	<-outputDone
+	targets := disc.Targets()
+	if targets == nil {
+		level.Info(logger).Log("msg", "no discovery round completed; output file not flushed")
+		return
+	}
+
+	if err := writeOutputFile(config.outputFile, targets); err != nil {
+		level.Error(logger).Log(
+			"msg", "failed to flush output file",
+			"file", config.outputFile,
+			"err", err,
+		)
+		return
+	}
+
+	level.Info(logger).Log("msg", "discovery stopped")
 }
 
 // TODO: Test what happens if bootstraps are down
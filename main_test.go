@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/keep-network/keep-core/pkg/clientinfo"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/keep-network/prometheus-sd/internal/utils"
+)
+
+func TestMain(m *testing.M) {
+	logger = log.NewNopLogger()
+	os.Exit(m.Run())
+}
+
+// parseFlags parses the command line into the configuration, setting defaults
+// of the flags not passed.
+func parseFlags(t *testing.T, args ...string) {
+	t.Helper()
+
+	// Repeatable flags append to the configured values, so the previous
+	// test's values are reset first.
+	*config = sdConfig{}
+
+	if _, err := app.Parse(args); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+}
+
+// newTestDiscovery creates a discovery configured with the flags of the run
+// command. Targets it sends are buffered in the returned channel.
+func newTestDiscovery(t *testing.T, flags ...string) (*discovery, chan []*targetgroup.Group) {
+	t.Helper()
+
+	parseFlags(t, append([]string{"run"}, flags...)...)
+
+	d, err := newDiscovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	ch := make(chan []*targetgroup.Group, 100)
+	d.stream = newTargetStream(ctx, ch, config.streamDebounce)
+
+	return d, ch
+}
+
+// resolvedPeer returns a peer with the diagnostics endpoint resolved.
+func resolvedPeer(chainAddress, endpoint string) *peerData {
+	return &peerData{
+		ChainAddress:       chainAddress,
+		NetworkID:          "16Uiu2HAm" + chainAddress,
+		ClientInfoEndpoint: endpoint,
+		Version:            "v2.0.0",
+	}
+}
+
+// fakeNode serves diagnostics of a node. Paths other than /diagnostics respond
+// with 404, and so does /diagnostics if the node serves no diagnostics.
+type fakeNode struct {
+	host string
+	port int
+
+	mutex       sync.Mutex
+	diagnostics *clientinfo.Diagnostics
+	requests    int
+}
+
+// newFakeNode starts a node listening on the address, like 127.0.0.1:0 or
+// [::1]:0. The test is skipped if the address can't be listened on.
+func newFakeNode(t *testing.T, address string, diagnostics *clientinfo.Diagnostics) *fakeNode {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", address, err)
+	}
+
+	node := &fakeNode{diagnostics: diagnostics}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		node.mutex.Lock()
+		defer node.mutex.Unlock()
+
+		node.requests++
+		if node.diagnostics == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(node.diagnostics)
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	tcpAddress := listener.Addr().(*net.TCPAddr)
+	node.host = tcpAddress.IP.String()
+	node.port = tcpAddress.Port
+
+	return node
+}
+
+// serve replaces the diagnostics served by the node; nil stops serving them.
+func (n *fakeNode) serve(diagnostics *clientinfo.Diagnostics) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.diagnostics = diagnostics
+}
+
+// requestCount returns the number of diagnostics requests the node has
+// received.
+func (n *fakeNode) requestCount() int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.requests
+}
+
+func (n *fakeNode) address() string {
+	return net.JoinHostPort(n.host, fmt.Sprintf("%d", n.port))
+}
+
+// multiAddress returns the node's address in the format reported for
+// connected peers.
+func (n *fakeNode) multiAddress() string {
+	protocol := "ip4"
+	if net.ParseIP(n.host).To4() == nil {
+		protocol = "ip6"
+	}
+	return fmt.Sprintf("/%s/%s/tcp/%d", protocol, n.host, n.port)
+}
+
+// nodeDiagnostics returns diagnostics of the node with the chain address
+// connected to the peers.
+func nodeDiagnostics(chainAddress string, peers ...clientinfo.Peer) *clientinfo.Diagnostics {
+	return &clientinfo.Diagnostics{
+		ClientInfo: clientinfo.Client{
+			ChainAddress: chainAddress,
+			NetworkID:    "16Uiu2HAm" + chainAddress,
+			Version:      "v2.0.0",
+		},
+		ConnectedPeers: peers,
+	}
+}
+
+// connectedPeer returns the peer as reported by a source connected to it
+// under the nodes' addresses.
+func connectedPeer(chainAddress string, nodes ...*fakeNode) clientinfo.Peer {
+	peer := clientinfo.Peer{
+		ChainAddress: chainAddress,
+		NetworkID:    "16Uiu2HAm" + chainAddress,
+	}
+	for _, node := range nodes {
+		peer.NetworkMultiAddresses = append(peer.NetworkMultiAddresses, node.multiAddress())
+	}
+	return peer
+}
+
+// firstUpdate runs the discovery until it sends the first target groups.
+func firstUpdate(t *testing.T, d *discovery) []*targetgroup.Group {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan []*targetgroup.Group)
+	go d.Run(ctx, ch)
+	defer func() {
+		cancel()
+		<-d.done
+	}()
+
+	select {
+	case tgs := <-ch:
+		return tgs
+	case <-time.After(10 * time.Second):
+		t.Fatal("no targets sent")
+		return nil
+	}
+}
+
+func TestIsAddressExcluded(t *testing.T) {
+	var tests = map[string]struct {
+		flags    []string
+		address  string
+		expected bool
+	}{
+		"public ipv4": {
+			address:  "192.0.2.1",
+			expected: false,
+		},
+		"banned ipv4": {
+			flags:    []string{"--scan.bannedAddress=192.0.2.1"},
+			address:  "192.0.2.1",
+			expected: true,
+		},
+		"banned ipv6 in another notation": {
+			flags:    []string{"--scan.bannedAddress=2001:db8:0:0:0:0:0:1"},
+			address:  "2001:db8::1",
+			expected: true,
+		},
+		"banned cidr": {
+			flags:    []string{"--scan.bannedAddress=2001:db8::/32"},
+			address:  "2001:db8::1",
+			expected: true,
+		},
+		"banned hostname": {
+			flags:    []string{"--scan.bannedAddress=node.example"},
+			address:  "node.example",
+			expected: true,
+		},
+		"ipv4 loopback": {
+			address:  "127.0.0.1",
+			expected: true,
+		},
+		"ipv6 loopback": {
+			address:  "::1",
+			expected: true,
+		},
+		"allowed ipv6 loopback": {
+			flags:    []string{"--scan.allowLoopbackAddresses"},
+			address:  "::1",
+			expected: false,
+		},
+		"banned allowed ipv6 loopback": {
+			flags:    []string{"--scan.allowLoopbackAddresses", "--scan.bannedAddress=0:0:0:0:0:0:0:1"},
+			address:  "::1",
+			expected: true,
+		},
+		"ipv6 link-local": {
+			flags:    []string{"--scan.allowLoopbackAddresses"},
+			address:  "fe80::1",
+			expected: true,
+		},
+		"ipv6 disabled": {
+			flags:    []string{"--no-scan.ipv6"},
+			address:  "2001:db8::1",
+			expected: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			parseFlags(t, append([]string{"run"}, test.flags...)...)
+
+			if excluded := isAddressExcluded(test.address); excluded != test.expected {
+				t.Errorf(
+					"unexpected exclusion of %s\nexpected: %v\nactual:   %v",
+					test.address,
+					test.expected,
+					excluded,
+				)
+			}
+		})
+	}
+}
+
+func TestDiscoverIPv6LoopbackPeer(t *testing.T) {
+	peer := newFakeNode(t, "[::1]:0", nodeDiagnostics("0xA"))
+	source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xS", connectedPeer("0xA", peer)))
+
+	parseFlags(
+		t,
+		"run",
+		"--source.address="+source.address(),
+		"--scan.allowLoopbackAddresses",
+		fmt.Sprintf("--scan.range=%d-%d", peer.port, peer.port),
+	)
+
+	d, err := newDiscovery()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tgs := firstUpdate(t, d)
+	if len(tgs) != 1 {
+		t.Fatalf("unexpected number of target groups: %d", len(tgs))
+	}
+
+	expectedAddress := model.LabelValue(fmt.Sprintf("[::1]:%d", peer.port))
+	if address := tgs[0].Labels[model.AddressLabel]; address != expectedAddress {
+		t.Errorf("unexpected address\nexpected: %s\nactual:   %s", expectedAddress, address)
+	}
+	if chainAddress := tgs[0].Labels[model.LabelName(labelChainAddress)]; chainAddress != "0xA" {
+		t.Errorf("unexpected chain address: %s", chainAddress)
+	}
+}
+
+func TestAddressPreferenceHelp(t *testing.T) {
+	var help string
+	for _, flag := range app.Model().Flags {
+		if flag.Name == "address.preference" {
+			help = flag.Help
+		}
+	}
+
+	for _, rule := range utils.AddressRuleNames {
+		if !strings.Contains(help, rule) {
+			t.Errorf("rule %s is not documented: %s", rule, help)
+		}
+
+		pattern := strings.Replace(rule, "<pattern>", ".*", 1)
+		if _, err := utils.ParseAddressRule(pattern); err != nil {
+			t.Errorf("listed rule %s is invalid: %v", rule, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	promdiscovery "github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// fileSDGroup is a target group in the file_sd format.
+type fileSDGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// runOutput writes the target groups sent by the discoverer to the output file
+// until the context is done. Like in the Prometheus custom SD adapter, the
+// groups are merged by sources and updates are throttled by the discovery
+// manager. The returned channel is closed once the file is not written
+// anymore, so a final flush can't be overwritten by an older update.
+func runOutput(ctx context.Context, file string, disc promdiscovery.Discoverer) <-chan struct{} {
+	manager := promdiscovery.NewManager(ctx, logger)
+	go manager.Run()
+	manager.StartCustomProvider(ctx, "keepNetworkPeerSD", disc)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var written []byte
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case providers := <-manager.SyncCh():
+				tgs := make([]*targetgroup.Group, 0)
+				for _, providerGroups := range providers {
+					tgs = append(tgs, providerGroups...)
+				}
+				sort.Slice(tgs, func(i, j int) bool {
+					return tgs[i].Source < tgs[j].Source
+				})
+
+				content, err := encodeOutput(tgs)
+				if err != nil {
+					level.Error(logger).Log("msg", "failed to encode output file", "err", err)
+					continue
+				}
+				if bytes.Equal(content, written) {
+					continue
+				}
+
+				if err := writeFileAtomically(file, content); err != nil {
+					level.Error(logger).Log(
+						"msg", "failed to write output file",
+						"file", file,
+						"err", err,
+					)
+					continue
+				}
+				written = content
+			}
+		}
+	}()
+
+	return done
+}
+
+// writeOutputFile atomically replaces the output file with the target groups
+// in the file_sd format.
+func writeOutputFile(file string, tgs []*targetgroup.Group) error {
+	content, err := encodeOutput(tgs)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(file, content)
+}
+
+// encodeOutput encodes the target groups in the file_sd format. Groups without
+// targets are skipped.
+func encodeOutput(tgs []*targetgroup.Group) ([]byte, error) {
+	groups := make([]fileSDGroup, 0, len(tgs))
+	for _, tg := range tgs {
+		if tg == nil || len(tg.Targets) == 0 {
+			continue
+		}
+
+		group := fileSDGroup{
+			Targets: make([]string, 0, len(tg.Targets)),
+			Labels:  make(map[string]string, len(tg.Labels)),
+		}
+		for _, target := range tg.Targets {
+			for _, value := range target {
+				group.Targets = append(group.Targets, string(value))
+			}
+		}
+		sort.Strings(group.Targets)
+		for name, value := range tg.Labels {
+			group.Labels[string(name)] = string(value)
+		}
+
+		groups = append(groups, group)
+	}
+
+	return json.MarshalIndent(groups, "", "    ")
+}
+
+// writeFileAtomically replaces the file with the content, so readers never see
+// a partially written file.
+func writeFileAtomically(file string, content []byte) error {
+	dir, _ := filepath.Split(file)
+	tmpFile, err := os.CreateTemp(dir, "sd-adapter")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.Write(content); err != nil {
+		return err
+	}
+
+	// Close the file before renaming for platforms that cannot move a file
+	// while a process is holding a file handle.
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// busyDiscoverer sends a new target group every few milliseconds until the
+// context is done.
+type busyDiscoverer struct{}
+
+func (busyDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	for i := 0; ; i++ {
+		tg := testGroup("0xA", fmt.Sprintf("192.0.2.10:%d", 1024+i))
+		select {
+		case ch <- []*targetgroup.Group{tg}:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func TestRunOutputStopsBeforeFlush(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "targets.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := runOutput(ctx, file, busyDiscoverer{})
+
+	// The discovery manager sends the first update after its throttling
+	// interval.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(file); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("output file not written")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("output writer didn't stop")
+	}
+
+	flushed := []*targetgroup.Group{testGroup("0xB", "192.0.2.11:9601")}
+	if err := writeOutputFile(file, flushed); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := encodeOutput(flushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The flushed targets are not overwritten by updates sent before.
+	time.Sleep(100 * time.Millisecond)
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != string(expected) {
+		t.Errorf("flushed targets overwritten\nexpected: %s\nactual:   %s", expected, content)
+	}
+}
+
+func TestEncodeOutput(t *testing.T) {
+	content, err := encodeOutput([]*targetgroup.Group{
+		{
+			Source:  "0xA",
+			Targets: []model.LabelSet{{model.AddressLabel: "192.0.2.10:9601"}},
+			Labels:  model.LabelSet{"__meta_chain_address": "0xA"},
+		},
+		{Source: "0xB"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[
+    {
+        "targets": [
+            "192.0.2.10:9601"
+        ],
+        "labels": {
+            "__meta_chain_address": "0xA"
+        }
+    }
+]`
+	if string(content) != expected {
+		t.Errorf("unexpected output\nexpected: %s\nactual:   %s", expected, content)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// staticTargetSourcePrefix prefixes sources of static target groups, so they
+// don't collide with sources of peer target groups.
+const staticTargetSourcePrefix = "static/"
+
+// applyOverrides sets fixed diagnostics endpoints and extra labels of peers
+// configured in the overrides file. Peers with a fixed endpoint not reported
+// by the sources are added to the peers.
+func (d *discovery) applyOverrides(peers map[string]*peerData) {
+	if d.overrides == nil {
+		return
+	}
+
+	overridesConfig := d.overrides.Get()
+
+	discovered := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		discovered[strings.ToLower(peer.ChainAddress)] = true
+
+		override, ok := overridesConfig.Lookup(peer.ChainAddress)
+		if !ok {
+			continue
+		}
+
+		peer.ExtraLabels = override.Labels
+		if override.Endpoint != "" {
+			peer.ClientInfoEndpoint = override.Endpoint
+			peer.FixedEndpoint = true
+		}
+	}
+
+	for _, override := range overridesConfig.Overrides {
+		if override.Endpoint == "" || discovered[strings.ToLower(override.ChainAddress)] {
+			continue
+		}
+
+		peers[override.ChainAddress] = &peerData{
+			ChainAddress:       override.ChainAddress,
+			ExtraLabels:        override.Labels,
+			ClientInfoEndpoint: override.Endpoint,
+			FixedEndpoint:      true,
+		}
+	}
+}
+
+// staticTargetGroups returns target groups of static targets configured in the
+// overrides file.
+func (d *discovery) staticTargetGroups() []*targetgroup.Group {
+	if d.overrides == nil {
+		return nil
+	}
+
+	staticTargets := d.overrides.Get().StaticTargets
+
+	groups := make([]*targetgroup.Group, 0, len(staticTargets))
+	for i, staticTarget := range staticTargets {
+		group := &targetgroup.Group{
+			Source:  fmt.Sprintf("%s%d", staticTargetSourcePrefix, i),
+			Targets: make([]model.LabelSet, 0, len(staticTarget.Targets)),
+			Labels:  staticTarget.Labels.Clone(),
+		}
+		for _, target := range staticTarget.Targets {
+			group.Targets = append(group.Targets, model.LabelSet{
+				model.AddressLabel: model.LabelValue(target),
+			})
+		}
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// triggerRound requests a discovery round to be run without waiting for the
+// ticker. Requests made while a round is already requested are merged.
+func (d *discovery) triggerRound() {
+	select {
+	case d.rerun <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/keep-network/prometheus-sd/internal/events"
+	"github.com/keep-network/prometheus-sd/internal/targetsource"
+)
+
+// discoveryPass is a part of the discovery run on its own schedule.
+type discoveryPass string
+
+const (
+	// pollPass polls the sources for peers and resolves new peers.
+	pollPass discoveryPass = "poll"
+	// verifyPass checks resolved endpoints still serve their peers.
+	verifyPass discoveryPass = "verify"
+	// scanPass scans ports of peers without a resolved endpoint.
+	scanPass discoveryPass = "scan"
+)
+
+// copyResolution copies the data resolved from the diagnostics endpoint of the
+// same peer seen in a previous pass.
+func (p *peerData) copyResolution(from *peerData) {
+	p.ClientInfoEndpoint = from.ClientInfoEndpoint
+	p.EndpointIP = from.EndpointIP
+	p.Version = from.Version
+	p.DiagnosticsNetworkID = from.DiagnosticsNetworkID
+	p.ConnectedPeers = from.ConnectedPeers
+	p.Verified = from.Verified
+	p.EndpointPaths = from.EndpointPaths
+}
+
+// clearResolution marks the peer as unresolved.
+func (p *peerData) clearResolution() {
+	p.copyResolution(&peerData{})
+}
+
+// poll collects peers from the sources. Peers seen in previous passes keep
+// their resolved endpoints; new peers are resolved right away.
+func (d *discovery) poll(ctx context.Context) {
+	// Get diagnostics from the source nodes (bootstrap nodes) to resolve
+	// the list of connected peers.
+	d.sourceDiagnostics = d.collectDiagnostics(ctx, config.listenAddresses)
+
+	// Combine results received from the source nodes to resolve a set of unique
+	// peers.
+	peers, networkIDConflicts := d.combineDiscoveredPeers(d.sourceDiagnostics)
+	d.networkIDConflicts = networkIDConflicts
+	d.applyOverrides(peers)
+	d.enrichPeers(peers)
+
+	level.Info(logger).Log(
+		"msg", fmt.Sprintf("discovered %d connected peers", len(peers)),
+	)
+	level.Debug(logger).Log(
+		"peers", fmt.Sprintf("%+v", peers),
+	)
+
+	newPeers := make([]*peerData, 0)
+	for chainAddress, peer := range peers {
+		if peer.FixedEndpoint {
+			level.Info(logger).Log(
+				"msg", "using fixed diagnostics endpoint",
+				"peer", chainAddress,
+				"endpoint", peer.ClientInfoEndpoint,
+			)
+			d.checkEndpointPaths(ctx, log.With(logger, "peer", chainAddress), peer)
+			continue
+		}
+
+		// Peers known from previous passes are verified and scanned on their
+		// own schedules.
+		if previous, ok := d.peers[chainAddress]; ok && !previous.FixedEndpoint {
+			peer.copyResolution(previous)
+			continue
+		}
+
+		newPeers = append(newPeers, peer)
+	}
+	d.peers = peers
+
+	d.resolvePeers(ctx, newPeers)
+}
+
+// verifyEndpoints checks the resolved endpoints still serve their peers'
+// diagnostics. Peers with endpoints that stopped working are unresolved until
+// the next scan pass.
+func (d *discovery) verifyEndpoints(ctx context.Context) {
+	for _, peer := range d.peers {
+		// Don't start verifying another peer if the discovery is shutting
+		// down.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if peer.FixedEndpoint || peer.ClientInfoEndpoint == "" {
+			continue
+		}
+
+		peerLogger := log.With(logger, "peer", peer.ChainAddress)
+
+		ok := d.checkKnownEndpoint(ctx, peerLogger, peer)
+		if ok {
+			d.verifyPeer(ctx, peerLogger, peer)
+			d.checkEndpointPaths(ctx, peerLogger, peer)
+		} else {
+			peer.clearResolution()
+			d.streamPeer(peer)
+		}
+		d.state.RecordPeer(peer.state(), ok, time.Now())
+	}
+}
+
+// scanUnresolved scans ports of peers without a resolved endpoint.
+func (d *discovery) scanUnresolved(ctx context.Context) {
+	unresolved := make([]*peerData, 0)
+	for _, peer := range d.peers {
+		if !peer.FixedEndpoint && peer.ClientInfoEndpoint == "" {
+			unresolved = append(unresolved, peer)
+		}
+	}
+
+	level.Info(logger).Log(
+		"msg", fmt.Sprintf("scanning %d unresolved peers", len(unresolved)),
+	)
+
+	d.resolvePeers(ctx, unresolved)
+}
+
+// resolvePeers finds diagnostics endpoints of the peers. The endpoint resolved
+// last for a peer is checked before its ports are scanned.
+func (d *discovery) resolvePeers(ctx context.Context, peers []*peerData) {
+	// TODO: Try use https://github.com/Ullaakut/nmap for ports scanning
+
+	// network address -> chain address -> port
+	discoveredPorts := make(map[string]map[string]int)
+
+	for _, peer := range peers {
+		// Don't start resolving another peer if the discovery is shutting
+		// down.
+		if ctx.Err() != nil {
+			return
+		}
+
+		peerLogger := log.With(logger, "peer", peer.ChainAddress)
+
+		peer.ClientInfoEndpoint = d.state.Endpoint(peer.ChainAddress)
+
+		resolved := d.resolvePeer(ctx, peerLogger, peer, discoveredPorts)
+		if resolved {
+			d.verifyPeer(ctx, peerLogger, peer)
+			d.checkEndpointPaths(ctx, peerLogger, peer)
+			d.streamPeer(peer)
+		} else {
+			peer.clearResolution()
+		}
+		d.state.RecordPeer(peer.state(), resolved, time.Now())
+	}
+}
+
+// publish builds targets of the peers and sends them to the adapter if they
+// changed. Alerts are evaluated and the history is recorded only for polls,
+// which count as discovery rounds. It returns false if the context is done.
+func (d *discovery) publish(
+	ctx context.Context,
+	pass discoveryPass,
+	startedAt time.Time,
+) bool {
+	d.locatePeers(d.peers)
+
+	level.Info(logger).Log(
+		"msg", fmt.Sprintf("discovery %s pass completed with %d peers", pass, len(d.peers)),
+	)
+
+	peerGroups := make(map[string][]*targetgroup.Group, len(d.peers)) // chain address -> groups
+	sources := make(map[string][]string, len(d.peers))                // chain address -> sources
+	for _, peer := range d.peers {
+		targets := d.createPeerTargets(peer)
+		if len(targets) == 0 {
+			level.Debug(logger).Log(
+				"msg", "no targets of the peer exported",
+				"peer", peer.ChainAddress,
+			)
+			continue
+		}
+
+		peerGroups[peer.ChainAddress] = targets
+		for _, target := range targets {
+			sources[peer.ChainAddress] = append(sources[peer.ChainAddress], target.Source)
+		}
+	}
+
+	tgs := make([]*targetgroup.Group, 0, len(peerGroups))
+	currentGroups := make(map[string]*targetgroup.Group, len(peerGroups))
+	owners := make(map[string]string, len(peerGroups)) // source -> chain address
+	for chainAddress, peerSources := range targetsource.Claim(sources) {
+		for i, source := range peerSources {
+			target := peerGroups[chainAddress][i]
+			if source != target.Source {
+				level.Warn(logger).Log(
+					"msg", "target source shared with another peer; suffixing it with the chain address",
+					"peer", chainAddress,
+					"source", target.Source,
+				)
+			}
+
+			target.Source = source
+			tgs = append(tgs, target)
+
+			currentGroups[source] = target
+			owners[source] = chainAddress
+		}
+	}
+	peerTargets := len(peerGroups)
+	for _, target := range d.staticTargetGroups() {
+		tgs = append(tgs, target)
+
+		currentGroups[target.Source] = target
+	}
+
+	changes := events.Diff(d.previousGroups, currentGroups, time.Now())
+	changed := d.forcePublish || len(changes) > 0
+
+	d.setTargets(tgs)
+	connectivityGraph := buildGraph(d.sourceDiagnostics, d.peers)
+	d.setGraph(connectivityGraph)
+
+	if d.metrics != nil {
+		d.metrics.Update(healthSnapshot(d.sourceDiagnostics, d.peers, d.networkIDConflicts, connectivityGraph))
+	}
+
+	d.publisher.Publish(changes)
+	d.previousGroups = currentGroups
+
+	if pass == pollPass {
+		if d.notifier != nil {
+			firing := evaluateAlerts(roundStats{
+				sources:            len(config.listenAddresses),
+				reachableSources:   len(d.sourceDiagnostics),
+				peers:              peerTargets,
+				previousPeers:      d.previousPeers,
+				networkIDConflicts: d.networkIDConflicts,
+				duration:           time.Since(startedAt),
+			})
+			if err := d.notifier.Notify(ctx, firing, time.Now()); err != nil {
+				level.Error(logger).Log("msg", "failed to notify alertmanager", "err", err)
+			}
+		}
+
+		d.previousPeers = peerTargets
+		d.state.Prune(time.Now().Add(-config.stateRetention))
+
+		if config.historyFile != "" {
+			if err := recordHistory(config.historyFile, time.Now(), d.peers); err != nil {
+				level.Error(logger).Log(
+					"msg", "failed to record peer history",
+					"file", config.historyFile,
+					"err", err,
+				)
+			}
+		}
+	}
+
+	if !changed {
+		return true
+	}
+
+	if d.elector != nil {
+		d.publishResult(tgs)
+	}
+
+	// We're returning all peer nodes targets as a single target group.
+	if !d.stream.Send(d.sources.Complete(tgs, owners)) {
+		return false
+	}
+	d.forcePublish = false
+	return true
+}
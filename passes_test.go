@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/keep-network/prometheus-sd/internal/alerting"
+	"github.com/keep-network/prometheus-sd/internal/history"
+)
+
+// receiveUpdate returns the next target groups sent by the discovery.
+func receiveUpdate(t *testing.T, ch <-chan []*targetgroup.Group) []*targetgroup.Group {
+	t.Helper()
+
+	select {
+	case tgs := <-ch:
+		return tgs
+	case <-time.After(5 * time.Second):
+		t.Fatal("no targets sent")
+		return nil
+	}
+}
+
+// assertNoUpdate checks no target groups have been sent.
+func assertNoUpdate(t *testing.T, ch <-chan []*targetgroup.Group) {
+	t.Helper()
+
+	select {
+	case tgs := <-ch:
+		t.Errorf("unexpected targets sent: %v", groupTargets(tgs))
+	default:
+	}
+}
+
+// drainUpdates discards target groups sent until none is sent for a while.
+func drainUpdates(ch <-chan []*targetgroup.Group) {
+	for {
+		select {
+		case <-ch:
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+// groupTargets returns numbers of targets by sources of the groups.
+func groupTargets(tgs []*targetgroup.Group) map[string]int {
+	targets := make(map[string]int, len(tgs))
+	for _, tg := range tgs {
+		targets[tg.Source] = len(tg.Targets)
+	}
+	return targets
+}
+
+func scanRangeFlag(port int) string {
+	return fmt.Sprintf("--scan.range=%d-%d", port, port)
+}
+
+func TestPollKeepsResolution(t *testing.T) {
+	nodeA := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xA"))
+	// Nodes listen on the same port, so the scan range covers both.
+	nodeB := newFakeNode(t, fmt.Sprintf("127.0.0.2:%d", nodeA.port), nodeDiagnostics("0xB"))
+	source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xS", connectedPeer("0xA", nodeA)))
+
+	d, _ := newTestDiscovery(
+		t,
+		"--source.address="+source.address(),
+		"--scan.allowLoopbackAddresses",
+		scanRangeFlag(nodeA.port),
+		"--stream.debounce=0",
+	)
+	ctx := context.Background()
+
+	d.poll(ctx)
+	if endpoint := d.peers["0xA"].ClientInfoEndpoint; endpoint != nodeA.address() {
+		t.Fatalf("unexpected endpoint of new peer\nexpected: %s\nactual:   %s", nodeA.address(), endpoint)
+	}
+
+	// The known peer keeps its endpoint without calling it, even if it
+	// stopped working; that's found by the verify pass. The new peer is
+	// resolved right away.
+	nodeA.serve(nil)
+	requests := nodeA.requestCount()
+	source.serve(nodeDiagnostics("0xS", connectedPeer("0xA", nodeA), connectedPeer("0xB", nodeB)))
+
+	d.poll(ctx)
+	if endpoint := d.peers["0xA"].ClientInfoEndpoint; endpoint != nodeA.address() {
+		t.Errorf("unexpected endpoint of known peer\nexpected: %s\nactual:   %s", nodeA.address(), endpoint)
+	}
+	if version := d.peers["0xA"].Version; version != "v2.0.0" {
+		t.Errorf("unexpected version of known peer: %s", version)
+	}
+	if nodeA.requestCount() != requests {
+		t.Errorf("known peer called by poll")
+	}
+	if endpoint := d.peers["0xB"].ClientInfoEndpoint; endpoint != nodeB.address() {
+		t.Errorf("unexpected endpoint of new peer\nexpected: %s\nactual:   %s", nodeB.address(), endpoint)
+	}
+}
+
+func TestVerifyEndpointsClearsBrokenPeer(t *testing.T) {
+	var tests = map[string]struct {
+		flags []string
+		// Source of the broken peer's group.
+		source string
+		// Whether the broken peer's group is cleared rather than updated
+		// with the endpoint removed.
+		expectedCleared bool
+	}{
+		"single target": {
+			source: "0xA",
+		},
+		"endpoint paths": {
+			flags:           []string{"--target.path=diagnostics=/diagnostics"},
+			source:          "0xA/diagnostics",
+			expectedCleared: true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			nodeA := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xA"))
+			nodeB := newFakeNode(t, fmt.Sprintf("127.0.0.2:%d", nodeA.port), nodeDiagnostics("0xB"))
+			source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics(
+				"0xS",
+				connectedPeer("0xA", nodeA),
+				connectedPeer("0xB", nodeB),
+			))
+
+			d, ch := newTestDiscovery(t, append(
+				[]string{
+					"--source.address=" + source.address(),
+					"--scan.allowLoopbackAddresses",
+					scanRangeFlag(nodeA.port),
+					"--stream.debounce=10ms",
+				},
+				test.flags...,
+			)...)
+			ctx := context.Background()
+
+			d.poll(ctx)
+			if !d.publish(ctx, pollPass, time.Now()) {
+				t.Fatal("publish failed")
+			}
+			drainUpdates(ch)
+
+			// The port serves another peer now.
+			nodeA.serve(nodeDiagnostics("0xC"))
+
+			d.verifyEndpoints(ctx)
+			if endpoint := d.peers["0xA"].ClientInfoEndpoint; endpoint != "" {
+				t.Errorf("broken endpoint not cleared: %s", endpoint)
+			}
+			if endpoint := d.peers["0xB"].ClientInfoEndpoint; endpoint != nodeB.address() {
+				t.Errorf("working endpoint cleared: %s", endpoint)
+			}
+
+			// The broken peer's group is updated without waiting for the end
+			// of the pass; the working peer's one is left alone.
+			tgs := receiveUpdate(t, ch)
+			if len(tgs) != 1 || tgs[0].Source != test.source {
+				t.Fatalf("unexpected groups sent: %v", groupTargets(tgs))
+			}
+			assertEndpointRemoved(t, tgs[0], test.expectedCleared)
+
+			stored := false
+			for _, tg := range d.Targets() {
+				if tg.Source == test.source {
+					stored = true
+					assertEndpointRemoved(t, tg, test.expectedCleared)
+				}
+			}
+			if stored == test.expectedCleared {
+				t.Errorf("unexpected stored groups: %v", groupTargets(d.Targets()))
+			}
+		})
+	}
+}
+
+// assertEndpointRemoved checks the group has no targets if it's expected to be
+// cleared, or a single target without an address otherwise.
+func assertEndpointRemoved(t *testing.T, tg *targetgroup.Group, expectedCleared bool) {
+	t.Helper()
+
+	if expectedCleared {
+		if len(tg.Targets) != 0 {
+			t.Errorf("group %s not cleared: %v", tg.Source, tg.Targets)
+		}
+		return
+	}
+
+	if len(tg.Targets) != 1 || tg.Targets[0][model.AddressLabel] != "" {
+		t.Errorf("endpoint not removed from group %s: %v", tg.Source, tg.Targets)
+	}
+}
+
+func TestScanResolvesOnlyUnresolved(t *testing.T) {
+	nodeA := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xA"))
+	nodeB := newFakeNode(t, fmt.Sprintf("127.0.0.2:%d", nodeA.port), nil)
+	source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics(
+		"0xS",
+		connectedPeer("0xA", nodeA),
+		connectedPeer("0xB", nodeB),
+	))
+
+	d, _ := newTestDiscovery(
+		t,
+		"--source.address="+source.address(),
+		"--scan.allowLoopbackAddresses",
+		scanRangeFlag(nodeA.port),
+		"--stream.debounce=0",
+	)
+	ctx := context.Background()
+
+	d.poll(ctx)
+	if endpoint := d.peers["0xB"].ClientInfoEndpoint; endpoint != "" {
+		t.Fatalf("peer without diagnostics resolved: %s", endpoint)
+	}
+
+	nodeB.serve(nodeDiagnostics("0xB"))
+	requests := nodeA.requestCount()
+
+	d.scanUnresolved(ctx)
+	if endpoint := d.peers["0xB"].ClientInfoEndpoint; endpoint != nodeB.address() {
+		t.Errorf("unexpected endpoint of unresolved peer\nexpected: %s\nactual:   %s", nodeB.address(), endpoint)
+	}
+	if nodeA.requestCount() != requests {
+		t.Errorf("resolved peer scanned")
+	}
+	if endpoint := d.peers["0xA"].ClientInfoEndpoint; endpoint != nodeA.address() {
+		t.Errorf("resolved peer's endpoint changed: %s", endpoint)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	alertmanager := &fakeAlertmanager{}
+	server := httptest.NewServer(alertmanager)
+	defer server.Close()
+
+	historyFile := filepath.Join(t.TempDir(), "history.db")
+
+	d, ch := newTestDiscovery(
+		t,
+		"--source.address=192.0.2.1:9701",
+		"--stream.debounce=0",
+		"--history.file="+historyFile,
+		"--alertmanager.url="+server.URL,
+	)
+	d.notifier = alerting.NewNotifier(
+		alerting.NewClient(config.alertmanagerURLs, time.Second),
+		3*config.refreshInterval,
+	)
+	ctx := context.Background()
+
+	d.peers = map[string]*peerData{"0xA": resolvedPeer("0xA", "192.0.2.10:9601")}
+
+	// The first targets are sent regardless of changes.
+	if !d.publish(ctx, verifyPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	if targets := groupTargets(receiveUpdate(t, ch)); targets["0xA"] != 1 {
+		t.Errorf("unexpected targets: %v", targets)
+	}
+
+	// Unchanged targets are not sent again.
+	if !d.publish(ctx, scanPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	assertNoUpdate(t, ch)
+
+	// Changed targets are sent.
+	d.peers["0xB"] = resolvedPeer("0xB", "192.0.2.11:9601")
+	if !d.publish(ctx, verifyPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	if targets := groupTargets(receiveUpdate(t, ch)); targets["0xA"] != 1 || targets["0xB"] != 1 {
+		t.Errorf("unexpected targets: %v", targets)
+	}
+
+	// Forced targets are sent, like after following the leader's ones.
+	d.forcePublish = true
+	if !d.publish(ctx, scanPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	receiveUpdate(t, ch)
+
+	// Passes other than polls neither evaluate alerts nor record history.
+	if posts := alertmanager.takePosts(); len(posts) != 0 {
+		t.Errorf("alerts posted by passes other than polls: %v", posts)
+	}
+	if _, err := os.Stat(historyFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("history recorded by passes other than polls: %v", err)
+	}
+
+	// Polls do, even if targets haven't changed.
+	if !d.publish(ctx, pollPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	assertNoUpdate(t, ch)
+
+	posts := alertmanager.takePosts()
+	if len(posts) != 1 {
+		t.Fatalf("unexpected number of alert posts: %d", len(posts))
+	}
+	assertAlerts(t, posts[0], []string{alertSourcesUnreachable}, nil)
+
+	store, err := history.Open(historyFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, chainAddress := range []string{"0xA", "0xB"} {
+		if _, err := store.Peer(chainAddress); err != nil {
+			t.Errorf("peer %s not recorded: %v", chainAddress, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/log"
+	"golang.org/x/exp/slices"
+)
+
+var (
+	probeCmd = app.Command("probe", "Diagnose discovery of a diagnostics endpoint for a single host.")
+
+	probeHost         string
+	probeChainAddress string
+	probeNetworkPort  int
+)
+
+func init() {
+	probeCmd.Arg(
+		"host",
+		"Network address of the peer to probe.",
+	).Required().StringVar(&probeHost)
+
+	probeCmd.Flag(
+		"probe.chainAddress",
+		"Chain address of the peer expected under the host. If not set, the peers are resolved from the sources.",
+	).Default("").StringVar(&probeChainAddress)
+
+	probeCmd.Flag(
+		"probe.networkPort",
+		"Network port of the peer. Required if the peer is not known to any of the sources.",
+	).Default("0").IntVar(&probeNetworkPort)
+}
+
+// probeStep is a single step of the diagnostics endpoint resolution captured
+// for the probe report.
+type probeStep struct {
+	name     string
+	target   string
+	duration time.Duration
+	err      error
+}
+
+type probeReport struct {
+	steps []probeStep
+}
+
+func (r *probeReport) record(step string, target string, startedAt time.Time, err error) {
+	r.steps = append(r.steps, probeStep{
+		name:     step,
+		target:   target,
+		duration: time.Since(startedAt),
+		err:      err,
+	})
+}
+
+func (r *probeReport) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, step := range r.steps {
+		status, reason := "ok", ""
+		if step.err != nil {
+			status, reason = "fail", step.err.Error()
+		}
+
+		fmt.Fprintf(
+			tw,
+			"  %d.\t[%s]\t%s\t%s\t%s\t%s\n",
+			i+1,
+			status,
+			step.name,
+			step.target,
+			step.duration.Round(time.Millisecond),
+			reason,
+		)
+	}
+	return tw.Flush()
+}
+
+// runProbe runs the discovery logic for the single host passed to the probe
+// command and prints a step-by-step report to the writer.
+func runProbe(ctx context.Context, w io.Writer) error {
+	disc, err := newDiscovery()
+	if err != nil {
+		return fmt.Errorf("failed to initiate discovery: %v", err)
+	}
+
+	// Resolve peers running under the host from the sources, the same way the
+	// discovery does.
+	startedAt := time.Now()
+	sourceDiagnostics := disc.collectDiagnostics(ctx, config.listenAddresses)
+	fmt.Fprintf(
+		w,
+		"collected diagnostics from %d of %d sources in %s\n",
+		len(sourceDiagnostics),
+		len(config.listenAddresses),
+		time.Since(startedAt).Round(time.Millisecond),
+	)
+
+	peers := make([]*peerData, 0)
+	discoveredPeers, _ := disc.combineDiscoveredPeers(sourceDiagnostics)
+	for _, peer := range discoveredPeers {
+		if !slices.Contains(peer.NetworkAddresses, probeHost) {
+			continue
+		}
+		if probeChainAddress != "" && peer.ChainAddress != probeChainAddress {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+
+	if len(peers) == 0 {
+		if probeChainAddress == "" || probeNetworkPort == 0 {
+			return fmt.Errorf(
+				"host %s is not known to any source; provide chain address and network port to probe it",
+				probeHost,
+			)
+		}
+
+		fmt.Fprintf(w, "host %s is not known to any source\n", probeHost)
+		peers = append(peers, &peerData{ChainAddress: probeChainAddress})
+	}
+
+	found := false
+	for _, peer := range peers {
+		if probeNetworkPort != 0 {
+			peer.NetworkPort = probeNetworkPort
+		}
+
+		fmt.Fprintf(
+			w,
+			"\nprobing host %s for peer %s (network ID: %s, network port: %d)\n",
+			probeHost,
+			peer.ChainAddress,
+			peer.NetworkID,
+			peer.NetworkPort,
+		)
+
+		report := &probeReport{}
+		startedAt := time.Now()
+		ok := disc.resolveEndpointAtAddress(
+			ctx,
+			log.With(logger, "peer", peer.ChainAddress),
+			peer,
+			probeHost,
+			make(map[string]map[string]int),
+			report.record,
+		)
+		if ok && disc.verificationEnabled() {
+			verifyStartedAt := time.Now()
+			report.record(
+				"identity verification",
+				peer.ClientInfoEndpoint,
+				verifyStartedAt,
+				disc.checkPeerIdentity(ctx, peer),
+			)
+		}
+		if err := report.print(w); err != nil {
+			return err
+		}
+
+		if ok {
+			found = true
+			fmt.Fprintf(
+				w,
+				"found diagnostics endpoint %s in %s\n",
+				peer.ClientInfoEndpoint,
+				time.Since(startedAt).Round(time.Millisecond),
+			)
+		} else {
+			fmt.Fprintf(
+				w,
+				"failed to find diagnostics endpoint in %s\n",
+				time.Since(startedAt).Round(time.Millisecond),
+			)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("failed to find diagnostics endpoint for host %s", probeHost)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// probeStepRegexp matches a step of the probe report, capturing its status
+// and name.
+var probeStepRegexp = regexp.MustCompile(`^\s+\d+\.\s+\[(\w+)\]\s+(\S+(?: \S+)*)`)
+
+func TestRunProbe(t *testing.T) {
+	peer := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xA"))
+	source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xS", connectedPeer("0xA", peer)))
+
+	webServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>It works!</body></html>")
+	}))
+	defer webServer.Close()
+	webServerPort := webServer.Listener.Addr().(*net.TCPAddr).Port
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	var tests = map[string]struct {
+		flags          []string
+		expectedSteps  []string
+		expectedOutput string
+		expectedError  bool
+	}{
+		"diagnostics found": {
+			flags: []string{
+				fmt.Sprintf("--scan.range=%d-%d", peer.port, peer.port),
+			},
+			expectedSteps: []string{
+				"ok exclusion check",
+				"ok network port reachability",
+				"ok port open",
+				"ok diagnostics fetch",
+				"ok chain address match",
+			},
+			expectedOutput: fmt.Sprintf("found diagnostics endpoint %s", peer.address()),
+		},
+		"unreachable network port": {
+			flags: []string{
+				fmt.Sprintf("--scan.range=%d-%d", peer.port, peer.port),
+				fmt.Sprintf("--probe.networkPort=%d", closedPort),
+			},
+			expectedSteps: []string{
+				"ok exclusion check",
+				"fail network port reachability",
+			},
+			expectedError: true,
+		},
+		"closed diagnostics port": {
+			flags: []string{
+				fmt.Sprintf("--scan.range=%d-%d", closedPort, closedPort),
+			},
+			expectedSteps: []string{
+				"ok exclusion check",
+				"ok network port reachability",
+				"fail port open",
+			},
+			expectedError: true,
+		},
+		"non-diagnostics response": {
+			flags: []string{
+				fmt.Sprintf("--scan.range=%d-%d", webServerPort, webServerPort),
+			},
+			expectedSteps: []string{
+				"ok exclusion check",
+				"ok network port reachability",
+				"ok port open",
+				"fail diagnostics fetch",
+			},
+			expectedOutput: "content_type: unexpected content type: text/html",
+			expectedError:  true,
+		},
+		"excluded address": {
+			flags: []string{
+				fmt.Sprintf("--scan.range=%d-%d", peer.port, peer.port),
+				"--scan.bannedAddress=127.0.0.0/8",
+			},
+			expectedSteps: []string{
+				"fail exclusion check",
+			},
+			expectedOutput: "address is excluded",
+			expectedError:  true,
+		},
+	}
+
+	for testName, test := range tests {
+		t.Run(testName, func(t *testing.T) {
+			parseFlags(t, append(
+				[]string{
+					"probe",
+					peer.host,
+					"--source.address=" + source.address(),
+					"--scan.allowLoopbackAddresses",
+				},
+				test.flags...,
+			)...)
+
+			var output bytes.Buffer
+			err := runProbe(context.Background(), &output)
+			if test.expectedError && err == nil {
+				t.Errorf("expected error\noutput:\n%s", output.String())
+			}
+			if !test.expectedError && err != nil {
+				t.Errorf("unexpected error: %v\noutput:\n%s", err, output.String())
+			}
+
+			if !strings.Contains(output.String(), test.expectedOutput) {
+				t.Errorf("output doesn't contain %q\noutput:\n%s", test.expectedOutput, output.String())
+			}
+
+			steps := make([]string, 0)
+			for _, line := range strings.Split(output.String(), "\n") {
+				if match := probeStepRegexp.FindStringSubmatch(line); match != nil {
+					steps = append(steps, match[1]+" "+match[2])
+				}
+			}
+			if !reflect.DeepEqual(steps, test.expectedSteps) {
+				t.Errorf(
+					"unexpected steps\nexpected: %v\nactual:   %v\noutput:\n%s",
+					test.expectedSteps,
+					steps,
+					output.String(),
+				)
+			}
+		})
+	}
+}
+
+func TestRunProbeUnknownHost(t *testing.T) {
+	source := newFakeNode(t, "127.0.0.1:0", nodeDiagnostics("0xS"))
+
+	parseFlags(t, "probe", "192.0.2.1", "--source.address="+source.address())
+
+	var output bytes.Buffer
+	if err := runProbe(context.Background(), &output); err == nil {
+		t.Errorf("expected error for a host unknown to the sources\noutput:\n%s", output.String())
+	}
+}
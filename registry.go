@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/go-kit/log/level"
+)
+
+// enrichPeers sets labels with metadata of peers' operators found in the
+// operators registry.
+func (d *discovery) enrichPeers(peers map[string]*peerData) {
+	if d.registry == nil {
+		return
+	}
+
+	operators := d.registry.Get()
+
+	unknown := 0
+	for _, peer := range peers {
+		operator, ok := operators.Lookup(peer.ChainAddress)
+		if !ok {
+			unknown++
+			continue
+		}
+
+		peer.OperatorLabels = operator.Labels()
+	}
+
+	if unknown > 0 {
+		level.Debug(logger).Log(
+			"msg", "peers not found in operators registry",
+			"count", unknown,
+		)
+	}
+}
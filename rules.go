@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/keep-network/prometheus-sd/internal/rules"
+)
+
+var (
+	rulesCmd         = app.Command("rules", "Generate Prometheus rules for the discovered targets.")
+	rulesGenerateCmd = rulesCmd.Command("generate", "Generate alerting and recording rules for the labels exported by the discovery.")
+
+	rulesConfig rules.Config
+	rulesOutput string
+)
+
+func init() {
+	rulesGenerateCmd.Flag(
+		"rules.nodesJob",
+		"Name of the Prometheus job scraping the discovered nodes.",
+	).Default("keep-network-nodes").StringVar(&rulesConfig.NodesJob)
+
+	rulesGenerateCmd.Flag(
+		"rules.sdJob",
+		"Name of the Prometheus job scraping the discovery's own metrics.",
+	).Default("keep-sd").StringVar(&rulesConfig.SDJob)
+
+	rulesGenerateCmd.Flag(
+		"rules.endpointKind",
+		"Kind of the nodes' targets the rules consider if peers have a target per --target.path, so each peer counts once. Targets without a kind are always considered. All targets are considered if empty.",
+	).Default("metrics").StringVar(&rulesConfig.EndpointKind)
+
+	rulesGenerateCmd.Flag(
+		"rules.peerDownFor",
+		"Time a peer has to be down before it is alerted on.",
+	).Default("5m").DurationVar(&rulesConfig.PeerDownFor)
+
+	rulesGenerateCmd.Flag(
+		"rules.versionLagFor",
+		"Time a peer has to run a lagging client version before it is alerted on.",
+	).Default("24h").DurationVar(&rulesConfig.VersionLagFor)
+
+	rulesGenerateCmd.Flag(
+		"rules.expectedVersion",
+		"Client version peers are expected to run. If empty, peers are expected to run the version most peers run, or any of the versions most peers run if there's a tie.",
+	).Default("").StringVar(&rulesConfig.ExpectedVersion)
+
+	rulesGenerateCmd.Flag(
+		"rules.peerDropThreshold",
+		"Fraction of peers that can disappear compared to the last hour's maximum before it is alerted on.",
+	).Default("0.2").Float64Var(&rulesConfig.PeerDropThreshold)
+
+	rulesGenerateCmd.Flag(
+		"rules.stalenessThreshold",
+		"Time without a completed discovery pass after which the discovery's output is considered stale.",
+	).Default("15m").DurationVar(&rulesConfig.StalenessThreshold)
+
+	rulesGenerateCmd.Flag(
+		"rules.output",
+		"File to write the rules to. The rules are printed if empty.",
+	).Default("").StringVar(&rulesOutput)
+}
+
+// runRulesGenerate generates the rules and writes them to the output file or
+// the writer.
+func runRulesGenerate(w io.Writer) error {
+	content, err := rules.Generate(rulesConfig)
+	if err != nil {
+		return fmt.Errorf("failed to generate rules: %v", err)
+	}
+
+	if rulesOutput == "" {
+		_, err := w.Write(content)
+		return err
+	}
+
+	if err := writeFileAtomically(rulesOutput, content); err != nil {
+		return fmt.Errorf("failed to write rules: %v", err)
+	}
+	fmt.Fprintf(w, "generated rules to %s\n", rulesOutput)
+
+	return nil
+}
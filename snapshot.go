@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/keep-network/prometheus-sd/internal/state"
+)
+
+var (
+	snapshotCmd       = app.Command("snapshot", "Export the discovery state to seed other instances.")
+	snapshotExportCmd = snapshotCmd.Command("export", "Export the discovery state of a running instance.")
+
+	snapshotURL     string
+	snapshotOutput  string
+	snapshotTimeout time.Duration
+)
+
+func init() {
+	snapshotExportCmd.Arg(
+		"url",
+		"URL of the running instance's web server, like http://localhost:8080.",
+	).Required().StringVar(&snapshotURL)
+
+	snapshotExportCmd.Flag(
+		"snapshot.output",
+		"File to write the snapshot to. The snapshot is printed if empty.",
+	).Default("").StringVar(&snapshotOutput)
+
+	snapshotExportCmd.Flag(
+		"snapshot.timeout",
+		"Timeout for fetching the snapshot.",
+	).Default("30s").DurationVar(&snapshotTimeout)
+}
+
+// state returns the peer's state recorded in the discovery state.
+func (p *peerData) state() state.Peer {
+	return state.Peer{
+		ChainAddress:     p.ChainAddress,
+		NetworkID:        p.NetworkID,
+		NetworkAddresses: p.NetworkAddresses,
+		NetworkPort:      p.NetworkPort,
+		Endpoint:         p.ClientInfoEndpoint,
+		Version:          p.Version,
+	}
+}
+
+// serveSnapshot serves the snapshot of the discovery state.
+func (d *discovery) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	state.Write(w, d.state.Snapshot(time.Now()))
+}
+
+// runSnapshotExport fetches the snapshot from the running instance and writes
+// it to the output file or the writer.
+func runSnapshotExport(ctx context.Context, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		strings.TrimSuffix(snapshotURL, "/")+"/snapshot",
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch snapshot: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch snapshot: unexpected status code: %d", resp.StatusCode)
+	}
+
+	// Validate the snapshot, so a broken one is not used to seed instances.
+	snapshot, err := state.Read(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if err := state.Write(&buffer, snapshot); err != nil {
+		return err
+	}
+
+	if snapshotOutput == "" {
+		_, err := w.Write(buffer.Bytes())
+		return err
+	}
+
+	if err := writeFileAtomically(snapshotOutput, buffer.Bytes()); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	fmt.Fprintf(
+		w,
+		"exported snapshot with %d sources, %d peers and %d ports to %s\n",
+		len(snapshot.Sources),
+		len(snapshot.Peers),
+		len(snapshot.Ports),
+		snapshotOutput,
+	)
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// targetStream sends target groups to the adapter. Partial updates of groups
+// resolved or lost in the middle of a pass are collected for the debounce
+// period and sent together, so the output file is not rewritten for each
+// peer.
+type targetStream struct {
+	ctx      context.Context
+	ch       chan<- []*targetgroup.Group
+	debounce time.Duration
+
+	// Guards the queued updates. Sending to the channel happens outside of
+	// it, so queueing updates never waits for the adapter.
+	mutex   sync.Mutex
+	pending map[string]*targetgroup.Group
+	timer   *time.Timer
+	// Incremented by each send of complete target groups. Partial updates
+	// collected in an earlier generation are superseded by them.
+	generation uint64
+
+	// Serializes sending to the channel, so partial updates collected before
+	// complete target groups are never sent after them.
+	sendMutex sync.Mutex
+}
+
+func newTargetStream(
+	ctx context.Context,
+	ch chan<- []*targetgroup.Group,
+	debounce time.Duration,
+) *targetStream {
+	return &targetStream{
+		ctx:      ctx,
+		ch:       ch,
+		debounce: debounce,
+		pending:  make(map[string]*targetgroup.Group),
+	}
+}
+
+// Update queues a partial update of the target group. The queued updates are
+// sent once the debounce period started by the first of them passes.
+func (s *targetStream) Update(tg *targetgroup.Group) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending[tg.Source] = tg
+	if s.timer == nil {
+		generation := s.generation
+		s.timer = time.AfterFunc(s.debounce, func() { s.flush(generation) })
+	}
+}
+
+// flush sends the partial updates queued in the generation, unless complete
+// target groups have been sent since.
+func (s *targetStream) flush(generation uint64) {
+	s.mutex.Lock()
+	if s.generation != generation {
+		// The timer fired while being stopped by Send; the updates it was
+		// started for have been dropped.
+		s.mutex.Unlock()
+		return
+	}
+
+	s.timer = nil
+	tgs := make([]*targetgroup.Group, 0, len(s.pending))
+	for _, tg := range s.pending {
+		tgs = append(tgs, tg)
+	}
+	s.pending = make(map[string]*targetgroup.Group)
+	s.mutex.Unlock()
+
+	if len(tgs) == 0 {
+		return
+	}
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	if s.currentGeneration() != generation {
+		return
+	}
+
+	level.Debug(logger).Log("msg", "sending partial targets update", "groups", len(tgs))
+
+	select {
+	case s.ch <- tgs:
+	case <-s.ctx.Done():
+	}
+}
+
+// Send sends the complete target groups and drops the queued partial updates
+// they supersede, including ones waiting to be sent. It returns false if the
+// context is done.
+func (s *targetStream) Send(tgs []*targetgroup.Group) bool {
+	s.mutex.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.pending = make(map[string]*targetgroup.Group)
+	s.generation++
+	s.mutex.Unlock()
+
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	select {
+	case s.ch <- tgs:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+func (s *targetStream) currentGeneration() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.generation
+}
+
+// streamPeer queues an update of the peer's target groups after its endpoint
+// has been resolved or lost in the middle of a pass. A peer with a single
+// target keeps its group after losing the endpoint, with the target's address
+// emptied. Groups sent before and no longer exported, like ones under a source
+// that changed with the endpoint or of endpoint paths that stopped responding
+// or were lost with the endpoint, are cleared. Nothing is queued if streaming
+// is disabled.
+func (d *discovery) streamPeer(peer *peerData) {
+	if config.streamDebounce == 0 {
+		return
+	}
+
+	d.locatePeers(map[string]*peerData{peer.ChainAddress: peer})
+
+	targets := d.createPeerTargets(peer)
+
+	sources := make([]string, 0, len(targets))
+	for _, target := range targets {
+		sources = append(sources, target.Source)
+	}
+	assigned, cleared := d.sources.Partial(peer.ChainAddress, sources)
+	for i, target := range targets {
+		target.Source = assigned[i]
+	}
+
+	for _, tg := range append(targets, cleared...) {
+		d.updateTargets(tg)
+		d.stream.Update(tg)
+	}
+}
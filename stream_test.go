@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+const testDebounce = 20 * time.Millisecond
+
+// testGroup returns a group with a single target of the address.
+func testGroup(source, address string) *targetgroup.Group {
+	return &targetgroup.Group{
+		Source:  source,
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(address)}},
+	}
+}
+
+// groupAddresses returns addresses of the groups' targets by sources of the
+// groups.
+func groupAddresses(tgs []*targetgroup.Group) map[string][]string {
+	addresses := make(map[string][]string, len(tgs))
+	for _, tg := range tgs {
+		addresses[tg.Source] = make([]string, 0, len(tg.Targets))
+		for _, target := range tg.Targets {
+			addresses[tg.Source] = append(addresses[tg.Source], string(target[model.AddressLabel]))
+		}
+		sort.Strings(addresses[tg.Source])
+	}
+	return addresses
+}
+
+func newTestStream(t *testing.T, ch chan []*targetgroup.Group) *targetStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return newTargetStream(ctx, ch, testDebounce)
+}
+
+func TestTargetStreamCoalescesUpdates(t *testing.T) {
+	ch := make(chan []*targetgroup.Group, 10)
+	stream := newTestStream(t, ch)
+
+	stream.Update(testGroup("0xA", "192.0.2.10:9601"))
+	stream.Update(testGroup("0xB", "192.0.2.11:9601"))
+	stream.Update(testGroup("0xA", "192.0.2.12:9601"))
+
+	expected := map[string][]string{
+		"0xA": {"192.0.2.12:9601"},
+		"0xB": {"192.0.2.11:9601"},
+	}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+
+	time.Sleep(5 * testDebounce)
+	assertNoUpdate(t, ch)
+}
+
+func TestTargetStreamSendDropsPendingUpdates(t *testing.T) {
+	ch := make(chan []*targetgroup.Group, 10)
+	stream := newTestStream(t, ch)
+
+	stream.Update(testGroup("0xA", "192.0.2.10:9601"))
+	if !stream.Send([]*targetgroup.Group{testGroup("0xA", "192.0.2.11:9601")}) {
+		t.Fatal("send failed")
+	}
+
+	expected := map[string][]string{"0xA": {"192.0.2.11:9601"}}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+
+	// The partial update is not sent after the complete groups superseding it.
+	time.Sleep(5 * testDebounce)
+	assertNoUpdate(t, ch)
+
+	// Updates queued after the complete groups are sent.
+	stream.Update(testGroup("0xA", "192.0.2.12:9601"))
+	expected = map[string][]string{"0xA": {"192.0.2.12:9601"}}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+}
+
+func TestTargetStreamBlockedSend(t *testing.T) {
+	ch := make(chan []*targetgroup.Group)
+	stream := newTestStream(t, ch)
+
+	// The adapter doesn't receive, so the partial update waits to be sent.
+	stream.Update(testGroup("0xA", "192.0.2.10:9601"))
+	time.Sleep(5 * testDebounce)
+
+	// Queueing updates doesn't wait for the adapter.
+	queued := make(chan struct{})
+	go func() {
+		stream.Update(testGroup("0xB", "192.0.2.11:9601"))
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(time.Second):
+		t.Fatal("update blocked by the pending send")
+	}
+
+	sent := make(chan bool)
+	go func() {
+		sent <- stream.Send([]*targetgroup.Group{testGroup("0xA", "192.0.2.12:9601")})
+	}()
+
+	// The update collected before the complete groups is sent before them;
+	// the one queued after it is superseded by them.
+	expected := map[string][]string{"0xA": {"192.0.2.10:9601"}}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected first update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+	expected = map[string][]string{"0xA": {"192.0.2.12:9601"}}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected second update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+	if !<-sent {
+		t.Error("send failed")
+	}
+
+	select {
+	case tgs := <-ch:
+		t.Errorf("unexpected update after complete groups: %v", groupAddresses(tgs))
+	case <-time.After(5 * testDebounce):
+	}
+}
+
+func TestStreamPeerClearsChangedSources(t *testing.T) {
+	d, ch := newTestDiscovery(
+		t,
+		"--source.address=192.0.2.1:9701",
+		"--stream.debounce=20ms",
+		"--target.sourceIdentity=endpoint",
+	)
+	ctx := context.Background()
+
+	d.peers = map[string]*peerData{"0xA": resolvedPeer("0xA", "192.0.2.10:9601")}
+	if !d.publish(ctx, verifyPass, time.Now()) {
+		t.Fatal("publish failed")
+	}
+	receiveUpdate(t, ch)
+
+	// The peer moved to another endpoint, so its group has another source.
+	d.peers["0xA"].ClientInfoEndpoint = "192.0.2.11:9601"
+	d.streamPeer(d.peers["0xA"])
+
+	expected := map[string][]string{
+		"endpoint/192.0.2.10:9601": {},
+		"endpoint/192.0.2.11:9601": {"192.0.2.11:9601"},
+	}
+	if addresses := groupAddresses(receiveUpdate(t, ch)); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected update\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+
+	expected = map[string][]string{"endpoint/192.0.2.11:9601": {"192.0.2.11:9601"}}
+	if addresses := groupAddresses(d.Targets()); !reflect.DeepEqual(addresses, expected) {
+		t.Errorf("unexpected stored groups\nexpected: %v\nactual:   %v", expected, addresses)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// verificationEnabled returns true if any of the identity checks is enabled.
+func (d *discovery) verificationEnabled() bool {
+	return config.verifyNetworkID || d.verifier != nil
+}
+
+// verifyPeer runs the enabled identity checks for the peer with resolved
+// diagnostics endpoint and stores the result in the peer's data.
+func (d *discovery) verifyPeer(ctx context.Context, peerLogger log.Logger, peer *peerData) {
+	if !d.verificationEnabled() {
+		return
+	}
+
+	if err := d.checkPeerIdentity(ctx, peer); err != nil {
+		level.Warn(peerLogger).Log(
+			"msg", "failed to verify peer identity",
+			"endpoint", peer.ClientInfoEndpoint,
+			"err", err,
+		)
+		peer.Verified = "false"
+		return
+	}
+
+	peer.Verified = "true"
+}
+
+// checkPeerIdentity verifies the host serving the peer's diagnostics is the
+// peer reported by the sources.
+func (d *discovery) checkPeerIdentity(ctx context.Context, peer *peerData) error {
+	if config.verifyNetworkID && peer.DiagnosticsNetworkID != peer.NetworkID {
+		return fmt.Errorf(
+			"network ID reported by diagnostics [%s] doesn't match the one reported by sources [%s]",
+			peer.DiagnosticsNetworkID,
+			peer.NetworkID,
+		)
+	}
+
+	if d.verifier != nil {
+		host, _, err := net.SplitHostPort(peer.ClientInfoEndpoint)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint %s: %v", peer.ClientInfoEndpoint, err)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, config.verifyHandshakeTimeout)
+		defer cancel()
+
+		err = d.verifier.VerifyPeerID(
+			ctx,
+			d.clients.ForPeer(peer.ChainAddress, host).Dialer(),
+			net.JoinHostPort(host, strconv.Itoa(peer.NetworkPort)),
+			peer.NetworkID,
+		)
+		if err != nil {
+			return fmt.Errorf("handshake failed: %v", err)
+		}
+	}
+
+	return nil
+}
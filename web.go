@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// webShutdownTimeout is the time given to the web server to finish handling
+// requests on shutdown.
+const webShutdownTimeout = 5 * time.Second
+
+// serveWeb starts the web server in the background. The server is shut down
+// when the context is done. Requests' contexts are derived from the context,
+// so long-lived streaming requests end on shutdown.
+func serveWeb(ctx context.Context, address string, handler http.Handler) {
+	server := &http.Server{
+		Addr:    address,
+		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		level.Info(logger).Log("msg", "starting web server", "address", address)
+
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			level.Error(logger).Log("msg", "web server failed", "err", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			level.Warn(logger).Log("msg", "failed to shut down web server", "err", err)
+		}
+	}()
+}